@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sseKeepAlive = 15 * time.Second
+
+func (h *Hub) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/devices", h.serveDevices)
+	mux.HandleFunc("/devices/", h.serveDeviceCommand)
+	mux.HandleFunc("/conflicts", h.serveConflicts)
+	mux.HandleFunc("/health", h.serveHealth)
+	mux.HandleFunc("/events", h.serveEvents)
+	mux.HandleFunc("/reload", h.serveReload)
+	mux.HandleFunc("/discovery", h.serveDiscovery)
+	mux.HandleFunc("/metrics", h.serveMetrics)
+	return mux
+}
+
+func (h *Hub) ServeAPI(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: h.apiHandler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (h *Hub) serveDevices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Devices())
+}
+
+// POST /devices/LAMP01 с телом on или off — команда пользователя
+// (класс user) через SetDeviceStatus. 202 — команда принята, в том числе
+// если слилась с уже стоящей в очереди или совпала с известным состоянием.
+func (h *Hub) serveDeviceCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/devices/")
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	on, err := parseOnOff(string(body))
+	if err == nil {
+		err = h.SetDeviceStatus(name, on)
+	}
+	if err == nil {
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+		return
+	}
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errBadSetPayload):
+		status = http.StatusBadRequest
+	case errors.Is(err, errDeviceNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errRateLimited):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, errQueueFull):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (h *Hub) serveConflicts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.registry.Conflicts())
+}
+
+func (h *Hub) serveHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.health.All())
+}
+
+func splitQuery(r *http.Request, key string) map[string]bool {
+	set := make(map[string]bool)
+	for _, val := range r.URL.Query()[key] {
+		for _, item := range strings.Split(val, ",") {
+			if item != "" {
+				set[item] = true
+			}
+		}
+	}
+	return set
+}
+
+// GET /events?device=LAMP01,SWITCH01&type=status_changed,trigger_fired
+// Server-Sent Events; для trigger_fired фильтр по имени срабатывает
+// и на источник, и на цель.
+func (h *Hub) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	devices := splitQuery(r, "device")
+	types := splitQuery(r, "type")
+	sub := h.events.Subscribe(64, DropOldest, func(e Event) bool {
+		if len(types) > 0 && !types[string(e.Type)] {
+			return false
+		}
+		return len(devices) == 0 || devices[e.Device.DevName] || devices[e.Source]
+	})
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-sub.C:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
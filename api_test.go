@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStream(t *testing.T) {
+	hub := newTestHub()
+	srv := httptest.NewServer(hub.apiHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events?device=LAMP01&type=device_added,status_changed")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	hub.mu.Lock()
+	hub.processingPayload(Payload{Src: 5, Dst: ALL, Serial: 1, DevType: LAMP, Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: "LAMP01"}})
+	hub.processingPayload(Payload{Src: 7, Dst: ALL, Serial: 2, DevType: SOCKET, Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: "SOCKET01"}})
+	hub.processingPayload(Payload{Src: 7, Dst: 1, Serial: 3, DevType: SOCKET, Cmd: STATUS, CmdBody: Flag(true)})
+	hub.processingPayload(Payload{Src: 5, Dst: 1, Serial: 4, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(true)})
+	hub.mu.Unlock()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				lines <- strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	var events []Event
+	for len(events) < 2 {
+		select {
+		case line := <-lines:
+			var e struct {
+				Type   EventType `json:"type"`
+				Device struct {
+					DevName string `json:"dev_name"`
+					Status  *bool  `json:"status"`
+				} `json:"device"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(line), &e))
+			assert.Equal(t, "LAMP01", e.Device.DevName)
+			events = append(events, Event{Type: e.Type})
+			if e.Type == EventStatusChanged {
+				assert.True(t, *e.Device.Status)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no events")
+		}
+	}
+	assert.Equal(t, []Event{{Type: EventDeviceAdded}, {Type: EventStatusChanged}}, events)
+}
+
+func TestDeviceCommand(t *testing.T) {
+	hub := newTestHub()
+	hub.requests.SetConfig(SchedulerConfig{Queues: map[string]QueueConfig{"user": {Capacity: 1}}})
+	hub.limiter.SetConfig(RateLimitConfig{PerDevice: RateLimit{Every: 1000, Burst: 1}})
+	hub.SaveDevice("LAMP01", 5, LAMP, nil)
+	hub.SaveDevice("LAMP02", 6, LAMP, nil)
+	srv := httptest.NewServer(hub.apiHandler())
+	defer srv.Close()
+
+	post := func(name, body string) int {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/devices/"+name, "text/plain", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusAccepted, post("LAMP01", "on"))
+	hub.mu.Lock()
+	p := hub.requests.Queue(ClassUser).At(0)
+	hub.mu.Unlock()
+	assert.Equal(t, VarUint(5), p.Dst)
+	assert.Equal(t, Flag(true), p.CmdBody)
+
+	assert.Equal(t, http.StatusServiceUnavailable, post("LAMP02", "on"))
+	hub.mu.Lock()
+	hub.requests.GetAllAndClear()
+	hub.mu.Unlock()
+	assert.Equal(t, http.StatusTooManyRequests, post("LAMP01", "off"))
+	assert.Equal(t, http.StatusNotFound, post("LAMP09", "on"))
+	assert.Equal(t, http.StatusBadRequest, post("LAMP02", "maybe"))
+
+	resp, err := http.Get(srv.URL + "/devices/LAMP01")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
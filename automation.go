@@ -0,0 +1,102 @@
+package main
+
+import "strconv"
+
+// Правила и расписания из конфига. Вызывается под h.mu из обработки пакетов.
+
+func envReadings(props EnvSensorProps, status EnvSensorStatusCmdBody) map[byte]VarUint {
+	readings := make(map[byte]VarUint, len(status.Values))
+	i := 0
+	for typeSensor := byte(0); typeSensor < 4 && i < len(status.Values); typeSensor++ {
+		if props.Sensors&(1<<typeSensor) != 0 {
+			readings[typeSensor] = status.Values[i]
+			i++
+		}
+	}
+	return readings
+}
+
+func (r Rule) matches(source Device, status Serializer) (on bool, ok bool) {
+	switch st := status.(type) {
+	case EnvSensorStatusCmdBody:
+		props, isEnv := source.Props.(EnvSensorProps)
+		if r.Sensor == "" || !isEnv {
+			return false, false
+		}
+		value, has := envReadings(props, st)[ruleSensors[r.Sensor]]
+		if !has {
+			return false, false
+		}
+		if (r.Above != nil && value > *r.Above) || (r.Below != nil && value < *r.Below) {
+			return r.Set == "on", true
+		}
+	case Flag:
+		if r.Sensor != "" || (r.State != "" && (r.State == "on") != bool(st)) {
+			return false, false
+		}
+		if r.Set == "follow" {
+			return bool(st), true
+		}
+		return r.Set == "on", true
+	}
+	return false, false
+}
+
+func (h *Hub) applyRules(source Device, status Serializer) {
+	if h.cfg == nil {
+		return
+	}
+	for _, rule := range h.cfg.Rules {
+		if rule.Source != source.DevName {
+			continue
+		}
+		on, ok := rule.matches(source, status)
+		if !ok {
+			continue
+		}
+		h.setTargets(rule.Target, on, "rule:"+rule.Name)
+	}
+}
+
+func (h *Hub) runSchedules() {
+	if h.cfg == nil {
+		return
+	}
+	if h.scheduleLast == nil {
+		h.scheduleLast = make(map[string]VarUint)
+	}
+	for i, s := range h.cfg.Schedules {
+		key := s.Name
+		if key == "" {
+			key = "#" + strconv.Itoa(i)
+		}
+		last, ok := h.scheduleLast[key]
+		if !ok {
+			h.scheduleLast[key] = h.now
+			continue
+		}
+		if h.now-last < s.Every {
+			continue
+		}
+		h.scheduleLast[key] = h.now
+		h.setTargets(s.Target, s.Set == "on", "schedule:"+s.Name)
+	}
+}
+
+func (h *Hub) setTargets(target string, on bool, source string) {
+	for _, name := range h.cfg.Targets(target) {
+		dev, ok := h.registry.ByName(name)
+		if !ok {
+			continue
+		}
+		sent, err := h.pushSetStatus(ClassAutomation, dev, on)
+		if err != nil {
+			logf(LogDebug, "%s: %s: %v", source, name, err)
+		}
+		if !sent {
+			continue
+		}
+		value := on
+		h.publish(Event{Type: EventTriggerFired, Device: dev, Source: source, On: &value})
+	}
+}
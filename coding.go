@@ -3,149 +3,325 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
-	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"sync"
 )
 
 var RawURLEncoding = base64.URLEncoding.WithPadding(-1)
 
+const maxFieldLen = 0xFF
+
+var (
+	errPayloadTooLarge   = errors.New("payload exceeds 255 bytes")
+	errAddressOutOfRange = errors.New("address exceeds 14 bits")
+	errStringTooLong     = errors.New("string exceeds 255 bytes")
+	errTooManyItems      = errors.New("array exceeds 255 items")
+)
+
 func serializeString(w io.Writer, str string) {
-	b := []byte(str)
-	w.Write(append([]byte{byte(len(b))}, b...))
+	w.Write(appendString(nil, str))
+}
+
+func appendString(dst []byte, str string) []byte {
+	dst = append(dst, byte(len(str)))
+	return append(dst, str...)
 }
 
 type Serializer interface {
 	Serialize(io.Writer)
+	Append([]byte) []byte
 }
 
-func (value VarUint) Serialize(w io.Writer) {
-	for value >= 0x80 {
-		binary.Write(w, binary.LittleEndian, byte(value)|0x80)
-		value >>= 7
+type validator interface {
+	validate() error
+}
+
+func validateString(str string) error {
+	if len(str) > maxFieldLen {
+		return fmt.Errorf("%w: %.16q...", errStringTooLong, str)
 	}
-	binary.Write(w, binary.LittleEndian, byte(value))
+	return nil
+}
+
+func validateCount(n int) error {
+	if n > maxFieldLen {
+		return fmt.Errorf("%w: %d", errTooManyItems, n)
+	}
+	return nil
+}
+
+func (value VarUint) Serialize(w io.Writer) {
+	var buf [maxVarUintLen]byte
+	w.Write(AppendVarUint(buf[:0], value))
+}
+
+func (value VarUint) Append(dst []byte) []byte {
+	return AppendVarUint(dst, value)
 }
 
 func (t TimerСmdBody) Serialize(w io.Writer) {
-	t.Timestamp.Serialize(w)
+	w.Write(t.Append(nil))
+}
+
+func (t TimerСmdBody) Append(dst []byte) []byte {
+	return AppendVarUint(dst, t.Timestamp)
 }
 
 func (d DeviceCmdBody) Serialize(w io.Writer) {
-	serializeString(w, d.DevName)
+	w.Write(d.Append(nil))
+}
+
+func (d DeviceCmdBody) validate() error {
+	if err := validateString(d.DevName); err != nil {
+		return fmt.Errorf("dev_name: %w", err)
+	}
+	if v, ok := d.DevProps.(validator); ok {
+		return v.validate()
+	}
+	return nil
+}
+
+func (d DeviceCmdBody) Append(dst []byte) []byte {
+	dst = appendString(dst, d.DevName)
 	if d.DevProps != nil {
-		d.DevProps.Serialize(w)
+		dst = d.DevProps.Append(dst)
 	}
+	return dst
 }
 
 func (s SerStrings) Serialize(w io.Writer) {
+	w.Write(s.Append(nil))
+}
+
+func (s SerStrings) validate() error {
+	if err := validateCount(len(s)); err != nil {
+		return fmt.Errorf("dev_names: %w", err)
+	}
+	for _, val := range s {
+		if err := validateString(val); err != nil {
+			return fmt.Errorf("dev_names: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s SerStrings) Append(dst []byte) []byte {
+	dst = append(dst, byte(len(s)))
 	for _, val := range s {
-		serializeString(w, val)
+		dst = appendString(dst, val)
 	}
+	return dst
 }
 
 func (s EnvSensorProps) Serialize(w io.Writer) {
-	binary.Write(w, binary.LittleEndian, s.Sensors)
+	w.Write(s.Append(nil))
+}
+
+func (s EnvSensorProps) validate() error {
+	if err := validateCount(len(s.Triggers)); err != nil {
+		return fmt.Errorf("triggers: %w", err)
+	}
 	for _, val := range s.Triggers {
-		binary.Write(w, binary.LittleEndian, val.Op)
-		val.Value.Serialize(w)
-		serializeString(w, val.Name)
+		if err := validateString(val.Name); err != nil {
+			return fmt.Errorf("trigger name: %w", err)
+		}
 	}
+	return nil
+}
 
+func (s EnvSensorProps) Append(dst []byte) []byte {
+	dst = append(dst, s.Sensors, byte(len(s.Triggers)))
+	for _, val := range s.Triggers {
+		dst = append(dst, val.Op)
+		dst = AppendVarUint(dst, val.Value)
+		dst = appendString(dst, val.Name)
+	}
+	return dst
 }
 
 func (s EnvSensorStatusCmdBody) Serialize(w io.Writer) {
+	w.Write(s.Append(nil))
+}
+
+func (s EnvSensorStatusCmdBody) validate() error {
+	if err := validateCount(len(s.Values)); err != nil {
+		return fmt.Errorf("values: %w", err)
+	}
+	return nil
+}
+
+func (s EnvSensorStatusCmdBody) Append(dst []byte) []byte {
+	dst = append(dst, byte(len(s.Values)))
 	for _, val := range s.Values {
-		val.Serialize(w)
+		dst = AppendVarUint(dst, val)
 	}
+	return dst
 }
 
 func (f Flag) Serialize(w io.Writer) {
+	w.Write(f.Append(nil))
+}
+
+func (f Flag) Append(dst []byte) []byte {
 	if f {
-		binary.Write(w, binary.LittleEndian, byte(1))
-	} else {
-		binary.Write(w, binary.LittleEndian, byte(0))
+		return append(dst, 1)
 	}
+	return append(dst, 0)
 }
 
-func serializeCmdBody(w io.Writer, cmd byte, cmdBody Serializer) {
+func appendCmdBody(dst []byte, cmd byte, cmdBody Serializer) []byte {
 	switch cmd {
 	case WHOISHERE, IAMHERE, GETSTATUS, STATUS, SETSTATUS, TICK:
-		cmdBody.Serialize(w)
+		return cmdBody.Append(dst)
 	default:
+		if raw, ok := cmdBody.(RawBody); ok {
+			return raw.Append(dst)
+		}
+		return dst
 	}
 }
 
 func serializePayload(w io.Writer, payload Payload) {
-	payload.Src.Serialize(w)
-	payload.Dst.Serialize(w)
-	payload.Serial.Serialize(w)
-	binary.Write(w, binary.LittleEndian, payload.DevType)
-	binary.Write(w, binary.LittleEndian, payload.Cmd)
+	w.Write(AppendPayload(nil, payload))
+}
+
+func AppendPayload(dst []byte, payload Payload) []byte {
+	dst = AppendVarUint(dst, payload.Src)
+	dst = AppendVarUint(dst, payload.Dst)
+	dst = AppendVarUint(dst, payload.Serial)
+	dst = append(dst, payload.DevType, payload.Cmd)
 	if payload.CmdBody != nil {
-		serializeCmdBody(w, payload.Cmd, payload.CmdBody)
+		dst = appendCmdBody(dst, payload.Cmd, payload.CmdBody)
+	}
+	return dst
+}
+
+func ValidatePayload(payload Payload) error {
+	if payload.Src > ALL {
+		return fmt.Errorf("src %#x: %w", payload.Src, errAddressOutOfRange)
+	}
+	if payload.Dst > ALL {
+		return fmt.Errorf("dst %#x: %w", payload.Dst, errAddressOutOfRange)
+	}
+	if v, ok := payload.CmdBody.(validator); ok {
+		if err := v.validate(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
+// length, payload, crc8
+func appendPacket(dst []byte, payload Payload) ([]byte, error) {
+	if err := ValidatePayload(payload); err != nil {
+		return dst, err
+	}
+	start := len(dst)
+	dst = append(dst, 0)
+	dst = AppendPayload(dst, payload)
+	length := len(dst) - start - 1
+	if length > maxFieldLen {
+		return dst[:start], fmt.Errorf("%w: %d", errPayloadTooLarge, length)
+	}
+	dst[start] = byte(length)
+	return append(dst, calculateCRC8(dst[start+1:])), nil
+}
+
+var crc8Table = [256]byte{0, 29, 58, 39, 116, 105, 78, 83, 232, 245, 210, 207, 156, 129, 166, 187,
+	205, 208, 247, 234, 185, 164, 131, 158, 37, 56, 31, 2, 81, 76, 107, 118,
+	135, 154, 189, 160, 243, 238, 201, 212, 111, 114, 85, 72, 27, 6, 33, 60,
+	74, 87, 112, 109, 62, 35, 4, 25, 162, 191, 152, 133, 214, 203, 236, 241,
+	19, 14, 41, 52, 103, 122, 93, 64, 251, 230, 193, 220, 143, 146, 181, 168,
+	222, 195, 228, 249, 170, 183, 144, 141, 54, 43, 12, 17, 66, 95, 120, 101,
+	148, 137, 174, 179, 224, 253, 218, 199, 124, 97, 70, 91, 8, 21, 50, 47,
+	89, 68, 99, 126, 45, 48, 23, 10, 177, 172, 139, 150, 197, 216, 255, 226,
+	38, 59, 28, 1, 82, 79, 104, 117, 206, 211, 244, 233, 186, 167, 128, 157,
+	235, 246, 209, 204, 159, 130, 165, 184, 3, 30, 57, 36, 119, 106, 77, 80,
+	161, 188, 155, 134, 213, 200, 239, 242, 73, 84, 115, 110, 61, 32, 7, 26,
+	108, 113, 86, 75, 24, 5, 34, 63, 132, 153, 190, 163, 240, 237, 202, 215,
+	53, 40, 15, 18, 65, 92, 123, 102, 221, 192, 231, 250, 169, 180, 147, 142,
+	248, 229, 194, 223, 140, 145, 182, 171, 16, 13, 42, 55, 100, 121, 94, 67,
+	178, 175, 136, 149, 198, 219, 252, 225, 90, 71, 96, 125, 46, 51, 20, 9,
+	127, 98, 69, 88, 11, 22, 49, 44, 151, 138, 173, 176, 227, 254, 217, 196}
+
 func calculateCRC8(payload []byte) byte {
-	СRC8_TABLE := [256]byte{0, 29, 58, 39, 116, 105, 78, 83, 232, 245, 210, 207, 156, 129, 166, 187,
-		205, 208, 247, 234, 185, 164, 131, 158, 37, 56, 31, 2, 81, 76, 107, 118,
-		135, 154, 189, 160, 243, 238, 201, 212, 111, 114, 85, 72, 27, 6, 33, 60,
-		74, 87, 112, 109, 62, 35, 4, 25, 162, 191, 152, 133, 214, 203, 236, 241,
-		19, 14, 41, 52, 103, 122, 93, 64, 251, 230, 193, 220, 143, 146, 181, 168,
-		222, 195, 228, 249, 170, 183, 144, 141, 54, 43, 12, 17, 66, 95, 120, 101,
-		148, 137, 174, 179, 224, 253, 218, 199, 124, 97, 70, 91, 8, 21, 50, 47,
-		89, 68, 99, 126, 45, 48, 23, 10, 177, 172, 139, 150, 197, 216, 255, 226,
-		38, 59, 28, 1, 82, 79, 104, 117, 206, 211, 244, 233, 186, 167, 128, 157,
-		235, 246, 209, 204, 159, 130, 165, 184, 3, 30, 57, 36, 119, 106, 77, 80,
-		161, 188, 155, 134, 213, 200, 239, 242, 73, 84, 115, 110, 61, 32, 7, 26,
-		108, 113, 86, 75, 24, 5, 34, 63, 132, 153, 190, 163, 240, 237, 202, 215,
-		53, 40, 15, 18, 65, 92, 123, 102, 221, 192, 231, 250, 169, 180, 147, 142,
-		248, 229, 194, 223, 140, 145, 182, 171, 16, 13, 42, 55, 100, 121, 94, 67,
-		178, 175, 136, 149, 198, 219, 252, 225, 90, 71, 96, 125, 46, 51, 20, 9,
-		127, 98, 69, 88, 11, 22, 49, 44, 151, 138, 173, 176, 227, 254, 217, 196}
 	var crc byte
 	for _, val := range payload {
-		crc = СRC8_TABLE[(crc^val)&0xff]
+		crc = crc8Table[crc^val]
 	}
 	return crc
 }
 
-func serializePayloadsToBase64URLEncoded(payloads []Payload) string {
+var encodeBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+func serializePayloadsToBase64URLEncoded(payloads []Payload) (string, error) {
+	ans, skipped := appendPayloadsToBase64URLEncoded(nil, payloads)
+	return string(ans), errors.Join(skipped...)
+}
+
+// checkPacket проверяет, что пакет закодируется, не дописывая его никуда.
+func checkPacket(payload Payload) error {
+	var buf [maxFieldLen + 2]byte
+	_, err := appendPacket(buf[:0], payload)
+	return err
+}
+
+func packetError(payload Payload, err error) error {
+	return fmt.Errorf("%s to %#x (serial %d): %w", CmdName(payload.Cmd), payload.Dst, payload.Serial, err)
+}
+
+// appendPackets дописывает пакеты в бинарном виде, без base64. Пакет,
+// который не кодируется, пропускается, остальные дописываются; по каждому
+// пропущенному возвращается ошибка.
+func appendPackets(dst []byte, payloads []Payload) ([]byte, []error) {
 	if len(payloads) == 1 && payloads[0].Cmd == 0 {
-		return ""
+		return dst, nil
 	}
-	var ans []byte
+	var skipped []error
 	for _, payload := range payloads {
-		buf := new(bytes.Buffer)
-		serializePayload(buf, payload)
-		ans = append(ans, byte(buf.Len()))
-		ans = append(ans, buf.Bytes()...)
-		ans = append(ans, calculateCRC8(buf.Bytes()))
+		var err error
+		if dst, err = appendPacket(dst, payload); err != nil {
+			skipped = append(skipped, packetError(payload, err))
+		}
 	}
+	return dst, skipped
+}
 
-	base64Str := RawURLEncoding.EncodeToString(ans)
-	return base64Str
+func appendPayloadsToBase64URLEncoded(dst []byte, payloads []Payload) ([]byte, []error) {
+	bufPtr := encodeBufPool.Get().(*[]byte)
+	bin, skipped := appendPackets((*bufPtr)[:0], payloads)
+	n := RawURLEncoding.EncodedLen(len(bin))
+	if cap(dst)-len(dst) < n {
+		grown := make([]byte, len(dst), len(dst)+n)
+		copy(grown, dst)
+		dst = grown
+	}
+	RawURLEncoding.Encode(dst[len(dst):len(dst)+n], bin)
+	*bufPtr = bin
+	encodeBufPool.Put(bufPtr)
+	return dst[:len(dst)+n], skipped
 }
 
 func checkSrc(payload []byte, src8 byte) bool {
 	return calculateCRC8(payload) == src8
 }
 
-func deserializeVarUint(bin []byte, startIndex, lastIndex int) (VarUint, int) {
-	var result, shift VarUint
-	i := startIndex
-	for i < lastIndex {
-		b := bin[i]
-		value := VarUint(b & 0x7F)
-		result |= value << shift
-		shift += 7
-		i++
-		if b&0x80 == 0 {
-			break
-		}
+func deserializeVarUint(bin []byte, startIndex, lastIndex int) (VarUint, int, error) {
+	if startIndex >= lastIndex {
+		return 0, startIndex, errVarUintTruncated
 	}
-	return result, i
+	value, n, err := ReadVarUint(bin[startIndex:lastIndex])
+	if err != nil {
+		return 0, startIndex, err
+	}
+	return value, startIndex + n, nil
 }
 
 func readString(bin []byte, startIndex, lastIndex int) (string, int, error) {
@@ -157,85 +333,27 @@ func readString(bin []byte, startIndex, lastIndex int) (string, int, error) {
 	return string(bin[startIndex : startIndex+length]), startIndex + length, nil
 }
 
-func deserializeEnvSensorProps(bin []byte, startIndex, lastIndex int) Serializer {
-	sensors := bin[startIndex]
-	startIndex++
-	lenghtTriggers := bin[startIndex]
-	startIndex++
-	triggers := make([]Trigger, 0, lenghtTriggers)
-	var err error
-	for startIndex < lastIndex {
-		trigger := Trigger{Op: bin[startIndex]}
-		startIndex++
-		trigger.Value, startIndex = deserializeVarUint(bin, startIndex, lastIndex)
-		trigger.Name, startIndex, err = readString(bin, startIndex, lastIndex)
-		if err != nil {
-			return nil
-		}
-		triggers = append(triggers, trigger)
-	}
-	return EnvSensorProps{Sensors: sensors, Triggers: triggers}
-
-}
-
-func deserializeDevPropsForSwitch(bin []byte, startIndex, lastIndex int) Serializer {
-	length := int(bin[startIndex])
-	startIndex++
-	names := make(SerStrings, 0, length)
-	var name string
-	var err error
-	for i := 0; i < length && startIndex < lastIndex; i++ {
-		name, startIndex, err = readString(bin, startIndex, lastIndex)
-		if err != nil {
-			return nil
-		}
-		names = append(names, name)
-	}
-	return names
-}
-
 func deserializeDevice(bin []byte, devType byte, startIndex, lastIndex int) Serializer {
 	devName, startIndex, err := readString(bin, startIndex, lastIndex)
 	if err != nil {
 		return nil
 	}
 	device := DeviceCmdBody{DevName: devName}
-	switch devType {
-	case ENVSENSOR:
-		device.DevProps = deserializeEnvSensorProps(bin, startIndex, lastIndex)
-	case SWITCH:
-		device.DevProps = deserializeDevPropsForSwitch(bin, startIndex, lastIndex)
-	case LAMP, SOCKET, CLOCK:
-	default:
-		return nil
+	if startIndex < lastIndex {
+		device.DevProps, err = lookupDeviceType(devType).DecodeProps(bin[startIndex:lastIndex])
+		if err != nil {
+			return nil
+		}
 	}
 	return device
 }
 
 func deserializeCmdBodyStatus(bin []byte, devType byte, startIndex, lastIndex int) Serializer {
-	switch devType {
-	case ENVSENSOR:
-		var value VarUint
-		length := int(bin[startIndex])
-		startIndex++
-		status := EnvSensorStatusCmdBody{make([]VarUint, 0, length)}
-		for i := 0; i < length; i++ {
-			value, startIndex = deserializeVarUint(bin, startIndex, lastIndex)
-			status.Values = append(status.Values, value)
-		}
-		return status
-	case SWITCH, LAMP, SOCKET:
-		var flag Flag
-		if bin[startIndex] == 0x01 {
-			flag = true
-		} else {
-			flag = false
-		}
-		startIndex++
-		return flag
-	default:
+	status, err := lookupDeviceType(devType).DecodeStatus(bin[startIndex:lastIndex])
+	if err != nil {
 		return nil
 	}
+	return status
 }
 
 func deserializeCmdBody(bin []byte, devType, cmd byte, startIndex, lastIndex int) (Serializer, error) {
@@ -248,10 +366,13 @@ func deserializeCmdBody(bin []byte, devType, cmd byte, startIndex, lastIndex int
 	case STATUS:
 		return deserializeCmdBodyStatus(bin, devType, startIndex, lastIndex), nil
 	case TICK:
-		timestamp, _ := deserializeVarUint(bin, startIndex, lastIndex)
+		timestamp, _, err := deserializeVarUint(bin, startIndex, lastIndex)
+		if err != nil {
+			return nil, err
+		}
 		return TimerСmdBody{Timestamp: timestamp}, nil
 	default:
-		return nil, errors.New("unknown cmd")
+		return decodeRaw(bin[startIndex:lastIndex])
 	}
 }
 
@@ -260,9 +381,25 @@ func deserializePayload(bin []byte, startIndex, length int) (Payload, error) {
 	if lastIndex >= len(bin) {
 		return Payload{}, errors.New("last index out of range")
 	}
-	src, startIndex := deserializeVarUint(bin, startIndex, lastIndex)
-	dst, startIndex := deserializeVarUint(bin, startIndex, lastIndex)
-	serial, startIndex := deserializeVarUint(bin, startIndex, lastIndex)
+	src, startIndex, err := deserializeVarUint(bin, startIndex, lastIndex)
+	if err != nil {
+		return Payload{}, err
+	}
+	dst, startIndex, err := deserializeVarUint(bin, startIndex, lastIndex)
+	if err != nil {
+		return Payload{}, err
+	}
+	// на такой адрес хаб не сможет ответить
+	if src > ALL || dst > ALL {
+		return Payload{}, fmt.Errorf("src %#x, dst %#x: %w", src, dst, errAddressOutOfRange)
+	}
+	serial, startIndex, err := deserializeVarUint(bin, startIndex, lastIndex)
+	if err != nil {
+		return Payload{}, err
+	}
+	if startIndex+2 > lastIndex {
+		return Payload{}, errors.New("payload too short")
+	}
 	devType := bin[startIndex]
 	startIndex++
 	cmd := bin[startIndex]
@@ -281,6 +418,9 @@ func deserializeFromBinaryFormToPayloads(bin []byte) []Payload {
 	for i < len(bin) {
 		length := int(bin[i])
 		i++
+		if i+length >= len(bin) {
+			break
+		}
 		payload, err := deserializePayload(bin, i, length)
 		if err != nil {
 			i += length + 1
@@ -298,10 +438,14 @@ func deserializeFromBinaryFormToPayloads(bin []byte) []Payload {
 }
 
 func decodeBase64ToPayloads(response []byte) []Payload {
-	binaryResponse, err := base64.RawURLEncoding.DecodeString(string(response))
+	payloads, _ := decodeBase64Payloads(response)
+	return payloads
+}
+
+func decodeBase64Payloads(response []byte) ([]Payload, error) {
+	binaryResponse, err := base64.RawURLEncoding.DecodeString(string(bytes.TrimSpace(response)))
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	payloads := deserializeFromBinaryFormToPayloads(binaryResponse)
-	return payloads
+	return deserializeFromBinaryFormToPayloads(binaryResponse), nil
 }
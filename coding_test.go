@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPayloads(n int) []Payload {
+	payloads := make([]Payload, 0, n)
+	for i := 0; i < n; i++ {
+		p := Payload{Src: 0x0EF0, Dst: VarUint(i % 0x3FFF), Serial: VarUint(i + 1), DevType: LAMP}
+		switch i % 3 {
+		case 0:
+			p.Cmd, p.CmdBody = SETSTATUS, Flag(i%2 == 0)
+		case 1:
+			p.Cmd = GETSTATUS
+		default:
+			p.DevType, p.Dst, p.Cmd, p.CmdBody = SMARTHUB, ALL, IAMHERE, DeviceCmdBody{DevName: "HUB01"}
+		}
+		payloads = append(payloads, p)
+	}
+	return payloads
+}
+
+// Замороженная копия исходного кодировщика на binary.Write — для тел,
+// формат которых с тех пор не менялся. Как и legacySerializeVarUint,
+// не должна вызывать текущий код кодирования.
+func legacySerializePayload(w io.Writer, payload Payload) {
+	legacySerializeString := func(str string) {
+		b := []byte(str)
+		w.Write(append([]byte{byte(len(b))}, b...))
+	}
+	legacySerializeVarUint(w, payload.Src)
+	legacySerializeVarUint(w, payload.Dst)
+	legacySerializeVarUint(w, payload.Serial)
+	binary.Write(w, binary.LittleEndian, payload.DevType)
+	binary.Write(w, binary.LittleEndian, payload.Cmd)
+	switch body := payload.CmdBody.(type) {
+	case nil:
+	case Flag:
+		if body {
+			binary.Write(w, binary.LittleEndian, byte(1))
+		} else {
+			binary.Write(w, binary.LittleEndian, byte(0))
+		}
+	case TimerСmdBody:
+		legacySerializeVarUint(w, body.Timestamp)
+	case DeviceCmdBody:
+		legacySerializeString(body.DevName)
+		props, _ := body.DevProps.(SerStrings)
+		for _, val := range props {
+			legacySerializeString(val)
+		}
+	default:
+		panic(fmt.Sprintf("legacy encoder: unsupported body %T", body))
+	}
+}
+
+// кодирование в том виде, в котором оно было до appendPacket
+func bufferedPayloadsToBase64(payloads []Payload) string {
+	var ans []byte
+	for _, payload := range payloads {
+		buf := new(bytes.Buffer)
+		legacySerializePayload(buf, payload)
+		ans = append(ans, byte(buf.Len()))
+		ans = append(ans, buf.Bytes()...)
+		ans = append(ans, calculateCRC8(buf.Bytes()))
+	}
+	return RawURLEncoding.EncodeToString(ans)
+}
+
+func TestAppendPayloadsMatchesBuffered(t *testing.T) {
+	payloads := testPayloads(100)
+	str, err := serializePayloadsToBase64URLEncoded(payloads)
+	assert.NoError(t, err)
+	assert.Equal(t, bufferedPayloadsToBase64(payloads), str)
+
+	dst, skipped := appendPayloadsToBase64URLEncoded([]byte("prefix"), payloads[:1])
+	assert.Empty(t, skipped)
+	str, _ = serializePayloadsToBase64URLEncoded(payloads[:1])
+	assert.Equal(t, "prefix"+str, string(dst))
+	str, _ = serializePayloadsToBase64URLEncoded([]Payload{{}})
+	assert.Empty(t, str)
+}
+
+func TestEncodeArraysRoundTrip(t *testing.T) {
+	payloads := []Payload{
+		{Src: 2, Dst: ALL, Serial: 1, DevType: ENVSENSOR, Cmd: IAMHERE, CmdBody: DeviceCmdBody{
+			DevName: "SENSOR01",
+			DevProps: EnvSensorProps{Sensors: 13, Triggers: []Trigger{
+				{Op: 12, Value: 100, Name: "OTHER1"},
+				{Op: 15, Value: 1200, Name: "OTHER2"},
+			}},
+		}},
+		{Src: 3, Dst: ALL, Serial: 2, DevType: SWITCH, Cmd: IAMHERE, CmdBody: DeviceCmdBody{
+			DevName:  "SWITCH01",
+			DevProps: SerStrings{"DEV01", "DEV02"},
+		}},
+		{Src: 2, Dst: 1, Serial: 3, DevType: ENVSENSOR, Cmd: STATUS, CmdBody: EnvSensorStatusCmdBody{Values: []VarUint{1, 500, 70000}}},
+	}
+	str, err := serializePayloadsToBase64URLEncoded(payloads)
+	assert.NoError(t, err)
+	assert.Equal(t, payloads, decodeBase64ToPayloads([]byte(str)))
+}
+
+func TestEncodeRejectsInvalidPayloads(t *testing.T) {
+	longName := string(bytes.Repeat([]byte{'A'}, 256))
+	cases := []struct {
+		payload Payload
+		err     error
+	}{
+		{Payload{Src: ALL + 1, Dst: 1, Cmd: GETSTATUS}, errAddressOutOfRange},
+		{Payload{Src: 1, Dst: 1 << 20, Cmd: GETSTATUS}, errAddressOutOfRange},
+		{Payload{Src: 1, Dst: ALL, Cmd: WHOISHERE, CmdBody: DeviceCmdBody{DevName: longName}}, errStringTooLong},
+		{Payload{Src: 1, Dst: ALL, Cmd: IAMHERE, CmdBody: DeviceCmdBody{
+			DevName:  "SENSOR01",
+			DevProps: EnvSensorProps{Triggers: make([]Trigger, 256)},
+		}}, errTooManyItems},
+		{Payload{Src: 1, Dst: ALL, Cmd: IAMHERE, CmdBody: DeviceCmdBody{
+			DevName:  "SWITCH01",
+			DevProps: SerStrings{longName[:200], longName[:200]},
+		}}, errPayloadTooLarge},
+	}
+	for _, c := range cases {
+		str, err := serializePayloadsToBase64URLEncoded([]Payload{testPayloads(1)[0], c.payload})
+		assert.ErrorIs(t, err, c.err)
+		// остальные пакеты всё равно кодируются
+		assert.Len(t, decodeBase64ToPayloads([]byte(str)), 1)
+	}
+}
+
+func BenchmarkEncodeBatch(b *testing.B) {
+	for _, n := range []int{1, 100, 10000} {
+		payloads := testPayloads(n)
+		b.Run(fmt.Sprintf("buffered/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bufferedPayloadsToBase64(payloads)
+			}
+		})
+		b.Run(fmt.Sprintf("append/%d", n), func(b *testing.B) {
+			var dst []byte
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dst, _ = appendPayloadsToBase64URLEncoded(dst[:0], payloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultHubName         = "HUB00"
+	defaultResponseTimeout = 300
+)
+
+var errInvalidConfig = errors.New("invalid config")
+
+// Duration читается из строк вида "250ms", "2s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+type RetryConfig struct {
+	Attempts int      `yaml:"attempts" json:"attempts"`
+	Backoff  Duration `yaml:"backoff" json:"backoff"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" json:"level"`
+	Format string `yaml:"format" json:"format"` // text | json
+	File   string `yaml:"file" json:"file"`
+}
+
+// Rule: когда приходит STATUS от Source, выставить Target (устройство или
+// группу) в Set. Для датчика — условие Sensor и порог Above/Below, для
+// устройств с Flag — необязательное условие State. Set: on, off или follow
+// (повторить Flag источника).
+type Rule struct {
+	Name   string   `yaml:"name" json:"name"`
+	Source string   `yaml:"source" json:"source"`
+	Sensor string   `yaml:"sensor,omitempty" json:"sensor,omitempty"`
+	Above  *VarUint `yaml:"above,omitempty" json:"above,omitempty"`
+	Below  *VarUint `yaml:"below,omitempty" json:"below,omitempty"`
+	State  string   `yaml:"state,omitempty" json:"state,omitempty"`
+	Target string   `yaml:"target" json:"target"`
+	Set    string   `yaml:"set" json:"set"`
+}
+
+// Schedule: каждые Every мс по часам TICK выставить Target в Set.
+type Schedule struct {
+	Name   string  `yaml:"name" json:"name"`
+	Every  VarUint `yaml:"every" json:"every"`
+	Target string  `yaml:"target" json:"target"`
+	Set    string  `yaml:"set" json:"set"`
+}
+
+type Config struct {
+	Name            string              `yaml:"name" json:"name"`
+	Address         string              `yaml:"address" json:"address"` // hex, как в аргументах
+	URL             string              `yaml:"url" json:"url"`
+	ResponseTimeout VarUint             `yaml:"response_timeout" json:"response_timeout"`
+	Retry           RetryConfig         `yaml:"retry" json:"retry"`
+	Log             LogConfig           `yaml:"log" json:"log"`
+	PersistPath     string              `yaml:"persist_path" json:"persist_path"`
+	APIListen       string              `yaml:"api_listen" json:"api_listen"`
+	ConflictPolicy  ConflictPolicy      `yaml:"conflict_policy" json:"conflict_policy"`
+	Health          HealthConfig        `yaml:"health" json:"health"`
+	Groups          map[string][]string `yaml:"groups" json:"groups"`
+	Rules           []Rule              `yaml:"rules" json:"rules"`
+	Schedules       []Schedule          `yaml:"schedules" json:"schedules"`
+	Poll            map[string]VarUint  `yaml:"poll" json:"poll"`
+	Discovery       DiscoveryConfig     `yaml:"discovery" json:"discovery"`
+	Scheduler       SchedulerConfig     `yaml:"scheduler" json:"scheduler"`
+	RateLimit       RateLimitConfig     `yaml:"rate_limit" json:"rate_limit"`
+	Transport       TransportConfig     `yaml:"transport" json:"transport"`
+	MQTT            MQTTConfig          `yaml:"mqtt" json:"mqtt"`
+
+	path    string
+	address VarUint
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Name:            defaultHubName,
+		ResponseTimeout: defaultResponseTimeout,
+		Retry:           RetryConfig{Attempts: 0, Backoff: Duration(200 * time.Millisecond)},
+		Log:             LogConfig{Level: "info", Format: "text"},
+		ConflictPolicy:  LastWins,
+		Health:          defaultHealthConfig(),
+		Transport:       defaultTransportConfig(),
+		MQTT:            defaultMQTTConfig(),
+	}
+}
+
+func readConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	cfg.path = path
+	return nil
+}
+
+// LoadConfig: значения по умолчанию < файл < переменные окружения <
+// позиционные аргументы (url, адрес) < флаги.
+func LoadConfig(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("hub", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	path := fs.String("config", getenv("HUB_CONFIG"), "path to YAML/JSON config")
+	name := fs.String("name", "", "hub name")
+	address := fs.String("address", "", "hub address (hex)")
+	serverURL := fs.String("url", "", "server URL")
+	timeout := fs.Uint64("timeout", 0, "response timeout in TICK ms")
+	apiListen := fs.String("api", "", "API listen address")
+	logLevel := fs.String("log-level", "", "debug, info, warn or error")
+	persist := fs.String("persist", "", "path to persisted device list")
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+
+	cfg := defaultConfig()
+	if *path != "" {
+		if err := readConfigFile(cfg, *path); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidConfig, err)
+		}
+	}
+
+	setString := func(dst *string, val string) {
+		if val != "" {
+			*dst = val
+		}
+	}
+	setString(&cfg.Name, getenv("HUB_NAME"))
+	setString(&cfg.Address, getenv("HUB_ADDRESS"))
+	setString(&cfg.URL, getenv("HUB_URL"))
+	setString(&cfg.APIListen, getenv("HUB_API_ADDR"))
+	setString(&cfg.Log.Level, getenv("HUB_LOG_LEVEL"))
+	setString(&cfg.PersistPath, getenv("HUB_PERSIST_PATH"))
+	setString(&cfg.MQTT.Broker, getenv("HUB_MQTT_BROKER"))
+	if val := getenv("HUB_RESPONSE_TIMEOUT"); val != "" {
+		t, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: HUB_RESPONSE_TIMEOUT: %v", errInvalidConfig, err)
+		}
+		cfg.ResponseTimeout = VarUint(t)
+	}
+
+	setString(&cfg.URL, fs.Arg(0))
+	setString(&cfg.Address, fs.Arg(1))
+
+	setString(&cfg.Name, *name)
+	setString(&cfg.Address, *address)
+	setString(&cfg.URL, *serverURL)
+	setString(&cfg.APIListen, *apiListen)
+	setString(&cfg.Log.Level, *logLevel)
+	setString(&cfg.PersistPath, *persist)
+	if *timeout != 0 {
+		cfg.ResponseTimeout = VarUint(*timeout)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+var ruleSensors = map[string]byte{
+	"temperature":  0,
+	"humidity":     1,
+	"illumination": 2,
+	"air":          3,
+}
+
+func validSetValue(set string, follow bool) bool {
+	return set == "on" || set == "off" || (follow && set == "follow")
+}
+
+// Validate собирает все ошибки сразу, чтобы их можно было исправить за раз.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Name == "" {
+		add("name: must not be empty")
+	} else if err := validateString(c.Name); err != nil {
+		add("name: %v", err)
+	}
+	if c.Address == "" {
+		add("address: required")
+	} else if adr, err := strconv.ParseUint(strings.TrimPrefix(c.Address, "0x"), 16, 64); err != nil {
+		add("address: %q is not a hex number", c.Address)
+	} else if VarUint(adr) >= ALL {
+		add("address: %#x out of range 0x0..0x3ffe", adr)
+	} else {
+		c.address = VarUint(adr)
+	}
+	if c.URL == "" {
+		add("url: required")
+	} else if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" {
+		add("url: %q is not an absolute URL", c.URL)
+	} else if _, ok := transportSchemes[u.Scheme]; !ok {
+		add("url: %v", fmt.Errorf("%w %q", errUnsupportedScheme, u.Scheme))
+	}
+	if c.ResponseTimeout == 0 {
+		add("response_timeout: must be positive")
+	}
+	if c.Retry.Attempts < 0 {
+		add("retry.attempts: must not be negative")
+	}
+	if c.Retry.Backoff < 0 {
+		add("retry.backoff: must not be negative")
+	}
+	if _, err := ParseLogLevel(c.Log.Level); err != nil {
+		add("log.level: %v", err)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		add("log.format: %q must be text or json", c.Log.Format)
+	}
+	if _, err := ParseConflictPolicy(string(c.ConflictPolicy)); err != nil {
+		add("conflict_policy: %v", err)
+	}
+	if hc := c.Health; hc.DegradedAfter < 1 || hc.DegradedAfter > hc.OfflineAfter || hc.OfflineAfter > hc.RemoveAfter {
+		add("health: need 1 <= degraded_after <= offline_after <= remove_after, got %d, %d, %d",
+			hc.DegradedAfter, hc.OfflineAfter, hc.RemoveAfter)
+	}
+	for group, members := range c.Groups {
+		if len(members) == 0 {
+			add("groups.%s: empty group", group)
+		}
+	}
+	for i, r := range c.Rules {
+		prefix := fmt.Sprintf("rules[%d]", i)
+		if r.Name != "" {
+			prefix += " " + r.Name
+		}
+		if r.Source == "" {
+			add("%s: source required", prefix)
+		}
+		if r.Target == "" {
+			add("%s: target required", prefix)
+		}
+		if r.Sensor != "" {
+			if _, ok := ruleSensors[r.Sensor]; !ok {
+				add("%s: unknown sensor %q", prefix, r.Sensor)
+			}
+			if (r.Above == nil) == (r.Below == nil) {
+				add("%s: sensor rule needs exactly one of above/below", prefix)
+			}
+			if r.State != "" {
+				add("%s: state is not used with sensor", prefix)
+			}
+		} else if r.Above != nil || r.Below != nil {
+			add("%s: above/below need sensor", prefix)
+		}
+		if r.State != "" && r.State != "on" && r.State != "off" {
+			add("%s: state %q must be on or off", prefix, r.State)
+		}
+		if !validSetValue(r.Set, r.Sensor == "") {
+			add("%s: set %q must be on, off or follow", prefix, r.Set)
+		}
+	}
+	for i, s := range c.Schedules {
+		prefix := fmt.Sprintf("schedules[%d]", i)
+		if s.Name != "" {
+			prefix += " " + s.Name
+		}
+		if s.Every == 0 {
+			add("%s: every must be positive", prefix)
+		}
+		if s.Target == "" {
+			add("%s: target required", prefix)
+		}
+		if !validSetValue(s.Set, false) {
+			add("%s: set %q must be on or off", prefix, s.Set)
+		}
+	}
+	if _, err := ParseCoalescePolicy(string(c.Scheduler.Coalesce)); err != nil {
+		add("scheduler.coalesce: %v", err)
+	}
+	if c.Scheduler.MaxBatch < 0 {
+		add("scheduler.max_batch: must not be negative")
+	}
+	if c.Scheduler.MaxBytes < 0 {
+		add("scheduler.max_bytes: must not be negative")
+	}
+	for name, limit := range c.Scheduler.Limits {
+		if _, err := ParseRequestClass(name); err != nil {
+			add("scheduler.limits: %v", err)
+		} else if limit < 0 {
+			add("scheduler.limits.%s: must not be negative", name)
+		}
+	}
+	for name, q := range c.Scheduler.Queues {
+		if _, err := ParseRequestClass(name); err != nil {
+			add("scheduler.queues: %v", err)
+			continue
+		}
+		if q.Capacity < 0 {
+			add("scheduler.queues.%s: capacity must not be negative", name)
+		}
+		if _, err := q.policy(); err != nil {
+			add("scheduler.queues.%s: %v", name, err)
+		}
+	}
+	for name, l := range map[string]RateLimit{"per_device": c.RateLimit.PerDevice, "global": c.RateLimit.Global} {
+		if l.Every > 0 && l.Burst < 1 {
+			add("rate_limit.%s: burst must be at least 1", name)
+		}
+	}
+	for name, every := range c.Poll {
+		dt, ok := lookupDeviceTypeByName(name)
+		switch {
+		case !ok:
+			add("poll.%s: unknown device type", name)
+		case !dt.Capabilities().GetStatus:
+			add("poll.%s: device type does not answer GETSTATUS", name)
+		case every == 0:
+			add("poll.%s: interval must be positive", name)
+		}
+	}
+	if m := c.MQTT; m.Broker != "" {
+		if u, err := url.Parse(m.Broker); err != nil || (u.Scheme != "tcp" && u.Scheme != "mqtt") || u.Host == "" {
+			add("mqtt.broker: %q must be tcp://host:port", m.Broker)
+		}
+		if m.Prefix == "" || strings.ContainsAny(m.Prefix, "+#") {
+			add("mqtt.prefix: %q must be non-empty and without wildcards", m.Prefix)
+		}
+		if m.KeepAlive < Duration(time.Second) || m.KeepAlive > Duration(0xFFFF*time.Second) {
+			add("mqtt.keepalive: must be between 1s and 65535s")
+		}
+		if m.DialTimeout <= 0 {
+			add("mqtt.dial_timeout: must be positive")
+		}
+		if m.Reconnect <= 0 {
+			add("mqtt.reconnect: must be positive")
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", errInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+// Targets раскрывает группу в список устройств.
+func (c *Config) Targets(name string) []string {
+	if members, ok := c.Groups[name]; ok {
+		return members
+	}
+	return []string{name}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "hub.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func noEnv(string) string { return "" }
+
+func TestLoadConfigPositionalArgs(t *testing.T) {
+	cfg, err := LoadConfig([]string{"http://localhost:9998", "ef0"}, noEnv)
+	require.NoError(t, err)
+	assert.Equal(t, defaultHubName, cfg.Name)
+	assert.Equal(t, VarUint(0xef0), cfg.address)
+	assert.Equal(t, VarUint(defaultResponseTimeout), cfg.ResponseTimeout)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+name: HUB01
+address: "0x10"
+url: http://file
+response_timeout: 500
+retry: {attempts: 3, backoff: 50ms}
+log: {level: debug, format: json}
+conflict_policy: quarantine
+groups:
+  hall: [LAMP01, LAMP02]
+rules:
+  - {name: hall, source: SWITCH01, target: hall, set: follow}
+  - {name: hot, source: SENSOR01, sensor: temperature, above: 30, target: SOCKET01, set: on}
+schedules:
+  - {name: night, every: 60000, target: hall, set: off}
+`)
+	env := map[string]string{"HUB_CONFIG": path, "HUB_URL": "http://env", "HUB_NAME": "HUB02"}
+	cfg, err := LoadConfig([]string{"-name", "HUB03"}, func(k string) string { return env[k] })
+	require.NoError(t, err)
+	assert.Equal(t, "HUB03", cfg.Name)
+	assert.Equal(t, "http://env", cfg.URL)
+	assert.Equal(t, VarUint(0x10), cfg.address)
+	assert.Equal(t, VarUint(500), cfg.ResponseTimeout)
+	assert.Equal(t, Duration(50*time.Millisecond), cfg.Retry.Backoff)
+	assert.Equal(t, Quarantine, cfg.ConflictPolicy)
+	assert.Equal(t, []string{"LAMP01", "LAMP02"}, cfg.Targets("hall"))
+	assert.Len(t, cfg.Rules, 2)
+
+	cfg, err = LoadConfig([]string{"-config", path, "http://arg"}, noEnv)
+	require.NoError(t, err)
+	assert.Equal(t, "http://arg", cfg.URL)
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	path := writeConfig(t, `
+address: zz
+url: nope
+response_timeout: 0
+log: {level: loud}
+conflict_policy: random
+rules:
+  - {source: SENSOR01, sensor: pressure, target: X, set: follow}
+schedules:
+  - {target: X, set: maybe}
+poll: {lamp: 0, clock: 100, toaster: 100}
+scheduler:
+  max_batch: -1
+  max_bytes: -1
+  limits: {urgent: 1}
+  coalesce: merge
+  queues: {urgent: {capacity: 1}, polling: {capacity: -1, overflow: block}}
+rate_limit: {per_device: {every: 1000}}
+mqtt: {broker: "http://broker", prefix: "home/#", keepalive: 0s, dial_timeout: 0s}
+`)
+	_, err := LoadConfig([]string{"-config", path}, noEnv)
+	require.ErrorIs(t, err, errInvalidConfig)
+	for _, msg := range []string{
+		`address: "zz" is not a hex number`,
+		`url: "nope" is not an absolute URL`,
+		"response_timeout: must be positive",
+		"log.level",
+		"conflict_policy",
+		`rules[0]: unknown sensor "pressure"`,
+		"rules[0]: sensor rule needs exactly one of above/below",
+		`rules[0]: set "follow" must be on, off or follow`,
+		"schedules[0]: every must be positive",
+		"poll.lamp: interval must be positive",
+		"poll.clock: device type does not answer GETSTATUS",
+		"poll.toaster: unknown device type",
+		"scheduler.max_batch: must not be negative",
+		"scheduler.max_bytes: must not be negative",
+		"rate_limit.per_device: burst must be at least 1",
+		`scheduler.queues: unknown request class "urgent"`,
+		"scheduler.queues.polling: capacity must not be negative",
+		`scheduler.queues.polling: unknown overflow policy "block": queues can't block`,
+		`scheduler.coalesce: unknown coalesce policy "merge"`,
+		`scheduler.limits: unknown request class "urgent"`,
+		`mqtt.broker: "http://broker" must be tcp://host:port`,
+		`mqtt.prefix: "home/#" must be non-empty and without wildcards`,
+		"mqtt.keepalive: must be between 1s and 65535s",
+		"mqtt.dial_timeout: must be positive",
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+
+	_, err = LoadConfig([]string{"-config", writeConfig(t, "nmae: typo\n")}, noEnv)
+	assert.ErrorIs(t, err, errInvalidConfig)
+	assert.Contains(t, err.Error(), "nmae")
+}
+
+func TestAutomationRules(t *testing.T) {
+	above := VarUint(30)
+	hub := newTestHub()
+	hub.cfg = &Config{
+		Groups: map[string][]string{"hall": {"LAMP01", "LAMP02"}},
+		Rules: []Rule{
+			{Name: "hall", Source: "SWITCH01", Target: "hall", Set: "follow"},
+			{Name: "hot", Source: "SENSOR01", Sensor: "humidity", Above: &above, Target: "SOCKET01", Set: "on"},
+		},
+		Schedules: []Schedule{{Name: "night", Every: 1000, Target: "LAMP01", Set: "off"}},
+	}
+	hub.SaveDevice("LAMP01", 10, LAMP, nil)
+	hub.SaveDevice("LAMP02", 11, LAMP, nil)
+	hub.SaveDevice("SOCKET01", 12, SOCKET, nil)
+	hub.SaveDevice("SWITCH01", 13, SWITCH, SerStrings{})
+	hub.SaveDevice("SENSOR01", 14, ENVSENSOR, EnvSensorProps{Sensors: 0b0011})
+
+	// устройства отвечают на SETSTATUS, иначе хаб удалит их по таймауту
+	setStatus := func() map[VarUint]Flag {
+		sent := make(map[VarUint]Flag)
+		for _, p := range hub.requests.GetAllAndClear() {
+			if p.Cmd == SETSTATUS {
+				sent[p.Dst] = p.CmdBody.(Flag)
+			}
+		}
+		for dst, on := range sent {
+			hub.processingPayload(Payload{Src: dst, Dst: 1, DevType: LAMP, Cmd: STATUS, CmdBody: on})
+		}
+		return sent
+	}
+
+	hub.processingPayload(Payload{Src: 13, Dst: 1, DevType: SWITCH, Cmd: STATUS, CmdBody: Flag(true)})
+	assert.Equal(t, map[VarUint]Flag{10: true, 11: true}, setStatus())
+
+	hub.processingPayload(Payload{Src: 14, Dst: 1, DevType: ENVSENSOR, Cmd: STATUS, CmdBody: EnvSensorStatusCmdBody{Values: []VarUint{40, 20}}})
+	assert.Empty(t, setStatus())
+	hub.processingPayload(Payload{Src: 14, Dst: 1, DevType: ENVSENSOR, Cmd: STATUS, CmdBody: EnvSensorStatusCmdBody{Values: []VarUint{20, 40}}})
+	assert.Equal(t, map[VarUint]Flag{12: true}, setStatus())
+
+	hub.processingPayload(tick(100))
+	hub.processingPayload(tick(600))
+	assert.Empty(t, setStatus())
+	hub.processingPayload(tick(1100))
+	assert.Equal(t, map[VarUint]Flag{10: false}, setStatus())
+}
+
+func TestPersistDevices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	r := NewRegistry()
+	r.Put("SENSOR01", 2, ENVSENSOR, EnvSensorProps{Sensors: 5, Triggers: []Trigger{{Op: 1, Value: 300, Name: "LAMP01"}}})
+	r.Put("SWITCH01", 3, SWITCH, SerStrings{"LAMP01"})
+	r.Put("LAMP01", 4, LAMP, nil)
+	require.NoError(t, saveDevices(path, r.All()))
+
+	loaded := NewRegistry()
+	require.NoError(t, loadDevices(path, loaded))
+	assert.Equal(t, r.All(), loaded.All())
+	assert.NoError(t, loadDevices(filepath.Join(t.TempDir(), "missing.json"), loaded))
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := writeConfig(t, "url: http://a\naddress: ef0\nrules: [{source: SWITCH01, target: LAMP01, set: follow}]\n")
+	load := func() (*Config, error) { return LoadConfig([]string{"-config", path}, noEnv) }
+	cfg, err := load()
+	require.NoError(t, err)
+	hub, err := NewHubFromConfig(cfg)
+	require.NoError(t, err)
+	hub.loadConfig = load
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub.WatchConfig(ctx, 10*time.Millisecond)
+
+	// неверный конфиг не применяется
+	require.NoError(t, os.WriteFile(path, []byte("url: http://a\naddress: ef0\nresponse_timeout: 0\n"), 0o644))
+	assert.ErrorIs(t, hub.Reload(), errInvalidConfig)
+	hub.mu.Lock()
+	assert.Len(t, hub.cfg.Rules, 1)
+	hub.mu.Unlock()
+
+	// имя не меняется без перезапуска, правила и таймауты — меняются
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.WriteFile(path, []byte("url: http://a\naddress: ef0\nname: OTHER\nresponse_timeout: 900\nconflict_policy: first_wins\n"), 0o644))
+	require.NoError(t, os.Chtimes(path, later, later))
+	assert.Eventually(t, func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return hub.wr.timeout == 900
+	}, time.Second, 5*time.Millisecond)
+	hub.mu.Lock()
+	assert.Empty(t, hub.cfg.Rules)
+	assert.Equal(t, defaultHubName, hub.cfg.Name)
+	hub.mu.Unlock()
+}
+
+func TestStatusPolling(t *testing.T) {
+	hub := newTestHub()
+	hub.wr = CreateWaitRequests()
+	hub.cfg = &Config{Poll: map[string]VarUint{"Lamp": 1000, "envsensor": 500}}
+	hub.SaveDevice("LAMP01", 10, LAMP, nil)
+	hub.SaveDevice("LAMP02", 11, LAMP, nil)
+	hub.SaveDevice("SOCKET01", 12, SOCKET, nil)
+	hub.SaveDevice("SENSOR01", 14, ENVSENSOR, EnvSensorProps{Sensors: 1})
+
+	polls := make(map[VarUint][]VarUint)
+	for ts := VarUint(0); ts <= 3000; ts += 100 {
+		hub.processingPayload(tick(ts))
+		for _, p := range hub.requests.GetAllAndClear() {
+			if p.Cmd != GETSTATUS {
+				continue
+			}
+			polls[p.Dst] = append(polls[p.Dst], ts)
+			// отвечает только LAMP01, остальные молчат до конца окна ожидания
+			if p.Dst == 10 {
+				hub.processingPayload(Payload{Src: 10, Dst: 1, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(true)})
+			}
+		}
+	}
+	assert.Len(t, polls[10], 3)
+	for i := 1; i < len(polls[10]); i++ {
+		assert.Equal(t, VarUint(1000), polls[10][i]-polls[10][i-1])
+	}
+	assert.Empty(t, polls[12])
+	assert.NotEqual(t, polls[10][0], polls[11][0])
+	dh, ok := hub.health.Get(10)
+	require.True(t, ok)
+	assert.Equal(t, HealthOnline, dh.State)
+	assert.Equal(t, 3, dh.Responses)
+	// молчащий датчик удалён после первого пропуска, и опрос прекратился
+	_, ok = hub.DeviceByName("SENSOR01")
+	assert.False(t, ok)
+	assert.Len(t, polls[14], 1)
+}
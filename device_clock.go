@@ -0,0 +1,17 @@
+package main
+
+type clockType struct{}
+
+func init() { mustRegisterDeviceType(clockType{}) }
+
+func (clockType) Code() byte                                  { return CLOCK }
+func (clockType) Name() string                                { return "Clock" }
+func (clockType) Capabilities() Capabilities                  { return Capabilities{} }
+func (clockType) DecodeProps(bin []byte) (Serializer, error)  { return nil, nil }
+func (clockType) DecodeStatus(bin []byte) (Serializer, error) { return decodeRaw(bin) }
+
+func (clockType) EncodeSetStatus(on bool) (Serializer, error) {
+	return nil, errSetStatusUnsupported
+}
+
+func (clockType) HandleStatus(h *Hub, device Device, status Serializer) {}
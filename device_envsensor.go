@@ -0,0 +1,113 @@
+package main
+
+type envSensorType struct{}
+
+func init() { mustRegisterDeviceType(envSensorType{}) }
+
+func (envSensorType) Code() byte   { return ENVSENSOR }
+func (envSensorType) Name() string { return "EnvSensor" }
+
+func (envSensorType) Capabilities() Capabilities {
+	return Capabilities{GetStatus: true, Sensors: true, Triggers: true}
+}
+
+func (envSensorType) DecodeProps(bin []byte) (Serializer, error) {
+	return deserializeEnvSensorProps(bin, 0, len(bin))
+}
+
+func (envSensorType) DecodeStatus(bin []byte) (Serializer, error) {
+	if len(bin) == 0 {
+		return nil, errEmptyBody
+	}
+	var value VarUint
+	var err error
+	startIndex := 0
+	length := int(bin[startIndex])
+	startIndex++
+	status := EnvSensorStatusCmdBody{make([]VarUint, 0, length)}
+	for i := 0; i < length; i++ {
+		value, startIndex, err = deserializeVarUint(bin, startIndex, len(bin))
+		if err != nil {
+			return nil, err
+		}
+		status.Values = append(status.Values, value)
+	}
+	return status, nil
+}
+
+func (envSensorType) EncodeSetStatus(on bool) (Serializer, error) {
+	return nil, errSetStatusUnsupported
+}
+
+func (envSensorType) HandleStatus(h *Hub, device Device, status Serializer) {
+	cmdBody, ok := status.(EnvSensorStatusCmdBody)
+	if !ok {
+		return
+	}
+	h.SaveStatus(device.Address, status)
+	props, ok := device.Props.(EnvSensorProps)
+	if !ok {
+		return
+	}
+	i := 0
+	typeSensor := byte(0)
+	for b := byte(1); b <= 8 && i < len(cmdBody.Values); b *= 2 {
+		if h.processingStatusSensor(device.DevName, props, b, cmdBody.Values[i], typeSensor) {
+			i++
+		}
+		typeSensor++
+	}
+}
+
+func deserializeEnvSensorProps(bin []byte, startIndex, lastIndex int) (Serializer, error) {
+	if startIndex+2 > lastIndex {
+		return nil, errEmptyBody
+	}
+	sensors := bin[startIndex]
+	startIndex++
+	lenghtTriggers := bin[startIndex]
+	startIndex++
+	triggers := make([]Trigger, 0, lenghtTriggers)
+	var err error
+	for startIndex < lastIndex {
+		trigger := Trigger{Op: bin[startIndex]}
+		startIndex++
+		trigger.Value, startIndex, err = deserializeVarUint(bin, startIndex, lastIndex)
+		if err != nil {
+			return nil, err
+		}
+		trigger.Name, startIndex, err = readString(bin, startIndex, lastIndex)
+		if err != nil {
+			return nil, err
+		}
+		triggers = append(triggers, trigger)
+	}
+	return EnvSensorProps{Sensors: sensors, Triggers: triggers}, nil
+}
+
+func (h *Hub) processingStatusSensor(source string, props EnvSensorProps, b byte, value VarUint, xType byte) bool {
+	if props.Sensors&b == 0 {
+		return false
+	}
+
+	for _, trigger := range props.Triggers {
+		typeSensor := (trigger.Op & 12) / 4
+		if typeSensor != xType {
+			continue
+		}
+		border := trigger.Value
+		comp := (trigger.Op & 2) / 2
+		dev, ok := h.registry.ByName(trigger.Name)
+		if !ok {
+			continue
+		}
+		if (comp == 1 && value > border) || (comp == 0 && value < border) {
+			on := trigger.Op&1 == 1
+			if sent, _ := h.pushSetStatus(ClassAutomation, dev, on); !sent {
+				continue
+			}
+			h.publish(Event{Type: EventTriggerFired, Device: dev, Source: source, On: &on})
+		}
+	}
+	return true
+}
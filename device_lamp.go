@@ -0,0 +1,20 @@
+package main
+
+type lampType struct{}
+
+func init() { mustRegisterDeviceType(lampType{}) }
+
+func (lampType) Code() byte   { return LAMP }
+func (lampType) Name() string { return "Lamp" }
+
+func (lampType) Capabilities() Capabilities {
+	return Capabilities{GetStatus: true, SetStatus: true}
+}
+
+func (lampType) DecodeProps(bin []byte) (Serializer, error)  { return nil, nil }
+func (lampType) DecodeStatus(bin []byte) (Serializer, error) { return decodeFlag(bin) }
+func (lampType) EncodeSetStatus(on bool) (Serializer, error) { return Flag(on), nil }
+
+func (lampType) HandleStatus(h *Hub, device Device, status Serializer) {
+	h.SaveStatus(device.Address, status)
+}
@@ -0,0 +1,20 @@
+package main
+
+type socketType struct{}
+
+func init() { mustRegisterDeviceType(socketType{}) }
+
+func (socketType) Code() byte   { return SOCKET }
+func (socketType) Name() string { return "Socket" }
+
+func (socketType) Capabilities() Capabilities {
+	return Capabilities{GetStatus: true, SetStatus: true}
+}
+
+func (socketType) DecodeProps(bin []byte) (Serializer, error)  { return nil, nil }
+func (socketType) DecodeStatus(bin []byte) (Serializer, error) { return decodeFlag(bin) }
+func (socketType) EncodeSetStatus(on bool) (Serializer, error) { return Flag(on), nil }
+
+func (socketType) HandleStatus(h *Hub, device Device, status Serializer) {
+	h.SaveStatus(device.Address, status)
+}
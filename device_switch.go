@@ -0,0 +1,68 @@
+package main
+
+type switchType struct{}
+
+func init() { mustRegisterDeviceType(switchType{}) }
+
+func (switchType) Code() byte   { return SWITCH }
+func (switchType) Name() string { return "Switch" }
+
+func (switchType) Capabilities() Capabilities {
+	return Capabilities{GetStatus: true}
+}
+
+func (switchType) DecodeProps(bin []byte) (Serializer, error) {
+	return deserializeDevPropsForSwitch(bin, 0, len(bin))
+}
+
+func (switchType) DecodeStatus(bin []byte) (Serializer, error) { return decodeFlag(bin) }
+
+func (switchType) EncodeSetStatus(on bool) (Serializer, error) {
+	return nil, errSetStatusUnsupported
+}
+
+func (switchType) HandleStatus(h *Hub, device Device, status Serializer) {
+	cmdBody, ok := status.(Flag)
+	if !ok {
+		return
+	}
+	h.SaveStatus(device.Address, status)
+	devices, ok := device.Props.(SerStrings)
+	if !ok {
+		return
+	}
+	h.processingStatusSwitch(device.DevName, devices, cmdBody)
+}
+
+func deserializeDevPropsForSwitch(bin []byte, startIndex, lastIndex int) (Serializer, error) {
+	if startIndex >= lastIndex {
+		return nil, errEmptyBody
+	}
+	length := int(bin[startIndex])
+	startIndex++
+	names := make(SerStrings, 0, length)
+	var name string
+	var err error
+	for i := 0; i < length && startIndex < lastIndex; i++ {
+		name, startIndex, err = readString(bin, startIndex, lastIndex)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (h *Hub) processingStatusSwitch(source string, props SerStrings, on Flag) {
+	for _, devName := range props {
+		device, ok := h.registry.ByName(devName)
+		if !ok {
+			continue
+		}
+		if sent, _ := h.pushSetStatus(ClassAutomation, device, bool(on)); !sent {
+			continue
+		}
+		value := bool(on)
+		h.publish(Event{Type: EventTriggerFired, Device: device, Source: source, On: &value})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+var (
+	errDeviceTypeRegistered = errors.New("device type already registered")
+	errSetStatusUnsupported = errors.New("device type does not support SETSTATUS")
+	errEmptyBody            = errors.New("empty body")
+)
+
+type Capabilities struct {
+	GetStatus bool `json:"get_status"`
+	SetStatus bool `json:"set_status"`
+	Sensors   bool `json:"sensors"`
+	Triggers  bool `json:"triggers"`
+}
+
+// Всё, что хаб знает о конкретном dev_type. Новый тип устройства —
+// это один файл с реализацией и вызовом RegisterDeviceType в init.
+type DeviceType interface {
+	Code() byte
+	Name() string
+	Capabilities() Capabilities
+	DecodeProps(bin []byte) (Serializer, error)
+	DecodeStatus(bin []byte) (Serializer, error)
+	EncodeSetStatus(on bool) (Serializer, error)
+	HandleStatus(h *Hub, device Device, status Serializer)
+}
+
+// Регистрировать тип можно и после Start: сеть и обработка читают
+// реестр типов из своих горутин, поэтому он под мьютексом.
+var (
+	deviceTypesMu sync.RWMutex
+	deviceTypes   = map[byte]DeviceType{}
+)
+
+func RegisterDeviceType(dt DeviceType) error {
+	deviceTypesMu.Lock()
+	defer deviceTypesMu.Unlock()
+	if _, ok := deviceTypes[dt.Code()]; ok {
+		return fmt.Errorf("%w: %#x", errDeviceTypeRegistered, dt.Code())
+	}
+	deviceTypes[dt.Code()] = dt
+	return nil
+}
+
+func mustRegisterDeviceType(dt DeviceType) {
+	if err := RegisterDeviceType(dt); err != nil {
+		panic(err)
+	}
+}
+
+func lookupDeviceType(devType byte) DeviceType {
+	deviceTypesMu.RLock()
+	dt, ok := deviceTypes[devType]
+	deviceTypesMu.RUnlock()
+	if ok {
+		return dt
+	}
+	return unknownDeviceType{code: devType}
+}
+
+func lookupDeviceTypeByName(name string) (DeviceType, bool) {
+	deviceTypesMu.RLock()
+	defer deviceTypesMu.RUnlock()
+	for _, dt := range deviceTypes {
+		if strings.EqualFold(dt.Name(), name) {
+			return dt, true
+		}
+	}
+	return nil, false
+}
+
+func DeviceTypeName(devType byte) string {
+	return lookupDeviceType(devType).Name()
+}
+
+// тело команды или устройства, которое хаб не умеет разбирать
+type RawBody []byte
+
+func (r RawBody) Serialize(w io.Writer) {
+	w.Write(r)
+}
+
+func (r RawBody) Append(dst []byte) []byte {
+	return append(dst, r...)
+}
+
+func decodeRaw(bin []byte) (Serializer, error) {
+	if len(bin) == 0 {
+		return nil, nil
+	}
+	return append(RawBody(nil), bin...), nil
+}
+
+func decodeFlag(bin []byte) (Serializer, error) {
+	if len(bin) == 0 {
+		return nil, errEmptyBody
+	}
+	return Flag(bin[0] == 0x01), nil
+}
+
+type unknownDeviceType struct {
+	code byte
+}
+
+func (u unknownDeviceType) Code() byte                                  { return u.code }
+func (u unknownDeviceType) Name() string                                { return "unknown" }
+func (u unknownDeviceType) Capabilities() Capabilities                  { return Capabilities{} }
+func (u unknownDeviceType) DecodeProps(bin []byte) (Serializer, error)  { return decodeRaw(bin) }
+func (u unknownDeviceType) DecodeStatus(bin []byte) (Serializer, error) { return decodeRaw(bin) }
+
+func (u unknownDeviceType) EncodeSetStatus(on bool) (Serializer, error) {
+	return nil, errSetStatusUnsupported
+}
+
+func (u unknownDeviceType) HandleStatus(h *Hub, device Device, status Serializer) {
+	h.SaveStatus(device.Address, status)
+}
+
+type smartHubType struct{}
+
+func init() { mustRegisterDeviceType(smartHubType{}) }
+
+func (smartHubType) Code() byte                                  { return SMARTHUB }
+func (smartHubType) Name() string                                { return "SmartHub" }
+func (smartHubType) Capabilities() Capabilities                  { return Capabilities{} }
+func (smartHubType) DecodeProps(bin []byte) (Serializer, error)  { return nil, nil }
+func (smartHubType) DecodeStatus(bin []byte) (Serializer, error) { return decodeRaw(bin) }
+
+func (smartHubType) EncodeSetStatus(on bool) (Serializer, error) {
+	return nil, errSetStatusUnsupported
+}
+
+func (smartHubType) HandleStatus(h *Hub, device Device, status Serializer) {}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+var errDiscoveryRunning = errors.New("discovery sweep already running")
+
+type DiscoveryConfig struct {
+	Every  VarUint `yaml:"every" json:"every"`   // мс по часам TICK, 0 — только по запросу
+	Window VarUint `yaml:"window" json:"window"` // 0 — response_timeout
+}
+
+type DeviceChange struct {
+	Old Device `json:"old"`
+	New Device `json:"new"`
+}
+
+// Итог повторного обнаружения относительно реестра на момент начала.
+type DiscoveryDiff struct {
+	Started  VarUint        `json:"started"`
+	Finished VarUint        `json:"finished"`
+	Found    int            `json:"found"`
+	New      []Device       `json:"new"`
+	Missing  []Device       `json:"missing"`
+	Renamed  []DeviceChange `json:"renamed"`
+	Retyped  []DeviceChange `json:"retyped"`
+}
+
+func (d *DiscoveryDiff) Empty() bool {
+	return len(d.New)+len(d.Missing)+len(d.Renamed)+len(d.Retyped) == 0
+}
+
+type sweep struct {
+	started VarUint
+	before  map[VarUint]Device
+	seen    map[VarUint]Device
+}
+
+// StartDiscovery рассылает WHOISHERE всем и собирает IAMHERE до конца окна.
+func (h *Hub) StartDiscovery() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.startSweep()
+}
+
+func (h *Hub) LastDiscovery() *DiscoveryDiff {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastDiscovery
+}
+
+func (h *Hub) startSweep() error {
+	if h.sweep != nil {
+		return errDiscoveryRunning
+	}
+	s := &sweep{started: h.now, before: make(map[VarUint]Device), seen: make(map[VarUint]Device)}
+	for _, dev := range h.registry.All() {
+		s.before[dev.Address] = dev
+	}
+	if _, err := h.requests.Push(ClassDiscovery, createWhoIsHereRequest(h)); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	h.sweep = s
+	logf(LogInfo, "discovery sweep started at %d", h.now)
+	return nil
+}
+
+func (h *Hub) discovered(address VarUint, devType byte, body DeviceCmdBody) {
+	if h.sweep != nil {
+		h.sweep.seen[address] = Device{DevName: body.DevName, Address: address, DevType: devType, Props: body.DevProps}
+	}
+}
+
+// Вызывается под h.mu из обработки TICK.
+func (h *Hub) runDiscovery() {
+	if h.sweep != nil {
+		window := h.wr.timeout
+		if h.cfg != nil && h.cfg.Discovery.Window > 0 {
+			window = h.cfg.Discovery.Window
+		}
+		if h.now-h.sweep.started >= window {
+			h.finishSweep()
+		}
+		return
+	}
+	if h.cfg == nil || h.cfg.Discovery.Every == 0 {
+		return
+	}
+	if h.discoveryNext == 0 {
+		h.discoveryNext = h.now + h.cfg.Discovery.Every
+	}
+	if h.now >= h.discoveryNext {
+		h.discoveryNext = h.now + h.cfg.Discovery.Every
+		h.startSweep()
+	}
+}
+
+func (h *Hub) finishSweep() {
+	s := h.sweep
+	h.sweep = nil
+	diff := &DiscoveryDiff{Started: s.started, Finished: h.now, Found: len(s.seen)}
+	for address, dev := range s.seen {
+		old, ok := s.before[address]
+		switch {
+		case !ok:
+			diff.New = append(diff.New, dev)
+		case old.DevType != dev.DevType:
+			diff.Retyped = append(diff.Retyped, DeviceChange{Old: old, New: dev})
+		case old.DevName != dev.DevName:
+			diff.Renamed = append(diff.Renamed, DeviceChange{Old: old, New: dev})
+		}
+	}
+	for address, dev := range s.before {
+		if _, ok := s.seen[address]; !ok {
+			diff.Missing = append(diff.Missing, dev)
+		}
+	}
+	sortDevices(diff.New)
+	sortDevices(diff.Missing)
+	sort.Slice(diff.Renamed, func(i, j int) bool { return diff.Renamed[i].New.Address < diff.Renamed[j].New.Address })
+	sort.Slice(diff.Retyped, func(i, j int) bool { return diff.Retyped[i].New.Address < diff.Retyped[j].New.Address })
+	h.lastDiscovery = diff
+	logf(LogInfo, "discovery sweep: %d found, %d new, %d missing, %d renamed, %d retyped",
+		diff.Found, len(diff.New), len(diff.Missing), len(diff.Renamed), len(diff.Retyped))
+	h.publish(Event{Type: EventDiscovery, Discovery: diff})
+}
+
+func sortDevices(devices []Device) {
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Address < devices[j].Address })
+}
+
+func (h *Hub) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, h.LastDiscovery())
+	case http.MethodPost:
+		if err := h.StartDiscovery(); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
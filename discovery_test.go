@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func iamhere(src VarUint, devType byte, name string) Payload {
+	return Payload{Src: src, Dst: ALL, Serial: 1, DevType: devType, Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: name}}
+}
+
+func TestDiscoverySweep(t *testing.T) {
+	hub := newTestHub()
+	hub.wr = CreateWaitRequests()
+	hub.wr.timeout = 10000
+	hub.cfg = &Config{Discovery: DiscoveryConfig{Every: 5000, Window: 200}}
+	sub := hub.Events().Subscribe(4, DropNewest, func(e Event) bool { return e.Type == EventDiscovery })
+	hub.SaveDevice("LAMP01", 10, LAMP, nil)
+	hub.SaveDevice("LAMP02", 11, LAMP, nil)
+	hub.SaveDevice("SOCKET01", 12, SOCKET, nil)
+	hub.SaveDevice("SWITCH01", 14, SWITCH, SerStrings{})
+
+	// IAMHERE вне окна обнаружения игнорируется
+	hub.processingPayload(iamhere(20, LAMP, "LATE"))
+	_, ok := hub.DeviceByName("LATE")
+	assert.False(t, ok)
+
+	hub.processingPayload(tick(1000))
+	require.NoError(t, hub.StartDiscovery())
+	assert.ErrorIs(t, hub.StartDiscovery(), errDiscoveryRunning)
+	whois := hub.requests.GetAllAndClear()
+	require.Len(t, whois, 1)
+	assert.Equal(t, WHOISHERE, whois[0].Cmd)
+	assert.Equal(t, VarUint(ALL), whois[0].Dst)
+
+	hub.processingPayload(iamhere(10, LAMP, "LAMP01"))
+	hub.processingPayload(iamhere(11, LAMP, "LAMP03"))
+	hub.processingPayload(iamhere(12, LAMP, "SOCKET01"))
+	hub.processingPayload(iamhere(13, SOCKET, "SOCKET02"))
+	hub.processingPayload(tick(1100))
+	assert.Nil(t, hub.LastDiscovery())
+	hub.processingPayload(tick(1200))
+
+	diff := hub.LastDiscovery()
+	require.NotNil(t, diff)
+	assert.Equal(t, VarUint(1000), diff.Started)
+	assert.Equal(t, VarUint(1200), diff.Finished)
+	assert.Equal(t, 4, diff.Found)
+	require.Len(t, diff.New, 1)
+	assert.Equal(t, "SOCKET02", diff.New[0].DevName)
+	require.Len(t, diff.Missing, 1)
+	assert.Equal(t, "SWITCH01", diff.Missing[0].DevName)
+	require.Len(t, diff.Renamed, 1)
+	assert.Equal(t, "LAMP02", diff.Renamed[0].Old.DevName)
+	assert.Equal(t, "LAMP03", diff.Renamed[0].New.DevName)
+	require.Len(t, diff.Retyped, 1)
+	assert.Equal(t, byte(SOCKET), diff.Retyped[0].Old.DevType)
+	assert.Equal(t, byte(LAMP), diff.Retyped[0].New.DevType)
+	_, ok = hub.DeviceByName("SOCKET02")
+	assert.True(t, ok)
+	assert.Equal(t, []EventType{EventDiscovery}, drain(sub))
+
+	// плановое обнаружение раз в discovery.every
+	hub.requests.GetAllAndClear()
+	hub.processingPayload(tick(5900))
+	assert.Zero(t, hub.requests.Len(ClassDiscovery))
+	hub.processingPayload(tick(6000))
+	assert.Equal(t, WHOISHERE, hub.requests.GetAllAndClear()[0].Cmd)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type EventType string
+
+const (
+	EventDeviceAdded   EventType = "device_added"
+	EventDeviceUpdated EventType = "device_updated"
+	EventDeviceRemoved EventType = "device_removed"
+	EventStatusChanged EventType = "status_changed"
+	EventTriggerFired  EventType = "trigger_fired"
+	EventTimeout       EventType = "timeout"
+	EventConflict      EventType = "conflict"
+	EventHealthChanged EventType = "health_changed"
+	EventDiscovery     EventType = "discovery"
+)
+
+type Event struct {
+	Type   EventType `json:"type"`
+	Tick   VarUint   `json:"tick"`
+	Device Device    `json:"device"`
+	// для trigger_fired: кто сработал и что отправлено устройству Device
+	Source    string         `json:"source,omitempty"`
+	On        *bool          `json:"on,omitempty"`
+	Conflict  *Conflict      `json:"conflict,omitempty"`
+	Health    *DeviceHealth  `json:"health,omitempty"`
+	Discovery *DiscoveryDiff `json:"discovery,omitempty"`
+}
+
+type OverflowPolicy byte
+
+const (
+	DropNewest OverflowPolicy = iota
+	DropOldest
+	Block
+	Reject // только для Ring: Push возвращает ошибку; подписка ведёт себя как с DropNewest
+)
+
+// EventBus раздаёт события подписчикам через буферизованные каналы.
+// Publish вызывается из обработки пакетов под h.mu, поэтому подписчик
+// с политикой Block тормозит весь хаб и не должен ждать сам хаб.
+type EventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	done    chan struct{}
+	policy  OverflowPolicy
+	filter  func(Event) bool
+	dropped atomic.Uint64
+	bus     *EventBus
+	once    sync.Once
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]struct{})}
+}
+
+// filter == nil — все события. Без буфера работает только Block: при
+// вытеснении старых событий буфер поднимается до одного, иначе
+// DropOldest крутился бы вечно под h.mu, а DropNewest терял бы всё.
+func (b *EventBus) Subscribe(buffer int, policy OverflowPolicy, filter func(Event) bool) *Subscription {
+	if buffer < 1 && policy != Block {
+		buffer = 1
+	}
+	ch := make(chan Event, buffer)
+	s := &Subscription{C: ch, ch: ch, done: make(chan struct{}), policy: policy, filter: filter, bus: b}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *EventBus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.filter == nil || s.filter(e) {
+			s.deliver(e)
+		}
+	}
+}
+
+func (s *Subscription) deliver(e Event) {
+	switch s.policy {
+	case Block:
+		select {
+		case s.ch <- e:
+		case <-s.done:
+		}
+		return
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- e:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close отписывает и закрывает C; блокированный Publish отпускается.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.ch)
+	})
+}
+
+func registryEvent(c RegistryChange) Event {
+	e := Event{Device: c.Device}
+	switch c.Kind {
+	case DeviceAdded:
+		e.Type = EventDeviceAdded
+	case DeviceUpdated:
+		e.Type = EventDeviceUpdated
+	case DeviceRemoved:
+		e.Type = EventDeviceRemoved
+	case StatusChanged:
+		e.Type = EventStatusChanged
+	case DeviceConflict:
+		e.Type = EventConflict
+		e.Conflict = c.Conflict
+	}
+	return e
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func drain(s *Subscription) []EventType {
+	var types []EventType
+	for {
+		select {
+		case e := <-s.C:
+			types = append(types, e.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestEventBusPolicies(t *testing.T) {
+	bus := NewEventBus()
+	newest := bus.Subscribe(2, DropNewest, nil)
+	oldest := bus.Subscribe(2, DropOldest, nil)
+	filtered := bus.Subscribe(2, DropNewest, func(e Event) bool { return e.Type == EventTimeout })
+	for _, typ := range []EventType{EventDeviceAdded, EventStatusChanged, EventTimeout} {
+		bus.Publish(Event{Type: typ})
+	}
+	assert.Equal(t, []EventType{EventDeviceAdded, EventStatusChanged}, drain(newest))
+	assert.Equal(t, uint64(1), newest.Dropped())
+	assert.Equal(t, []EventType{EventStatusChanged, EventTimeout}, drain(oldest))
+	assert.Equal(t, []EventType{EventTimeout}, drain(filtered))
+
+	// без буфера подписка с вытеснением держит одно последнее событие
+	unbuffered := bus.Subscribe(0, DropOldest, nil)
+	bus.Publish(Event{Type: EventDeviceAdded})
+	bus.Publish(Event{Type: EventTimeout})
+	assert.Equal(t, []EventType{EventTimeout}, drain(unbuffered))
+	unbuffered.Close()
+
+	blocking := bus.Subscribe(0, Block, nil)
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Type: EventTimeout})
+		close(published)
+	}()
+	assert.Equal(t, EventTimeout, (<-blocking.C).Type)
+	<-published
+
+	go bus.Publish(Event{Type: EventTimeout})
+	time.Sleep(10 * time.Millisecond)
+	blocking.Close()
+	_, ok := <-blocking.C
+	assert.False(t, ok)
+}
+
+func TestHubPublishesEvents(t *testing.T) {
+	hub := newTestHub()
+	sub := hub.Events().Subscribe(64, DropNewest, nil)
+	hub.processingPayload(tick(100))
+	hub.processingPayload(Payload{Src: 5, Dst: ALL, Serial: 1, DevType: LAMP, Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: "LAMP01"}})
+	hub.processingPayload(Payload{Src: 6, Dst: ALL, Serial: 2, DevType: SWITCH, Cmd: IAMHERE,
+		CmdBody: DeviceCmdBody{DevName: "SWITCH01", DevProps: SerStrings{"LAMP01"}}})
+	hub.processingPayload(Payload{Src: 6, Dst: 1, Serial: 3, DevType: SWITCH, Cmd: STATUS, CmdBody: Flag(true)})
+	assert.Equal(t, []EventType{EventDeviceAdded, EventDeviceAdded, EventStatusChanged, EventTriggerFired}, drain(sub))
+
+	hub.processingPayload(tick(500))
+	hub.processingPayload(tick(900))
+	events := drain(sub)
+	assert.Contains(t, events, EventTimeout)
+	assert.Contains(t, events, EventDeviceRemoved)
+}
+
+func TestTriggerFiredOnlyWhenSent(t *testing.T) {
+	hub := newTestHub()
+	hub.requests.SetConfig(SchedulerConfig{Coalesce: CoalesceFirstWins})
+	fired := hub.Events().Subscribe(64, DropNewest, func(e Event) bool { return e.Type == EventTriggerFired })
+	hub.processingPayload(Payload{Src: 5, Dst: ALL, Serial: 1, DevType: LAMP, Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: "LAMP01"}})
+	hub.processingPayload(Payload{Src: 6, Dst: ALL, Serial: 2, DevType: SWITCH, Cmd: IAMHERE,
+		CmdBody: DeviceCmdBody{DevName: "SWITCH01", DevProps: SerStrings{"LAMP01"}}})
+	switchOn := Payload{Src: 6, Dst: 1, Serial: 3, DevType: SWITCH, Cmd: STATUS, CmdBody: Flag(true)}
+	hub.processingPayload(switchOn)
+	assert.Len(t, drain(fired), 1)
+
+	// слита с командой, которая уже стоит в очереди
+	hub.processingPayload(switchOn)
+	assert.Empty(t, drain(fired))
+
+	// запросы ушли, лампа ответила на каждый: повторять нечего
+	for _, p := range hub.requests.GetAllAndClear() {
+		if p.Dst == 5 {
+			hub.processingPayload(Payload{Src: 5, Dst: 1, Serial: p.Serial, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(true)})
+		}
+	}
+	hub.processingPayload(switchOn)
+	assert.Empty(t, drain(fired))
+	assert.Zero(t, hub.requests.Size())
+}
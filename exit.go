@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Коды завершения процесса. Супервизору имеет смысл перезапускать хаб
+// при ExitTransport и ExitInternal; ExitConfig требует правки конфига;
+// ExitOK и ExitShutdown — штатная остановка (для systemd:
+// SuccessExitStatus=6).
+const (
+	ExitOK             = 0  // сервер завершил сессию (HTTP 204)
+	ExitConfig         = 2  // ошибка конфигурации или аргументов
+	ExitTransport      = 3  // сеть недоступна, обрыв соединения
+	ExitProtocol       = 4  // не удалось закодировать или разобрать пакеты
+	ExitServerRejected = 5  // сервер ответил кодом, отличным от 200 и 204
+	ExitShutdown       = 6  // остановка по SIGINT/SIGTERM
+	ExitInternal       = 99 // всё остальное
+)
+
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string { return "config: " + e.Err.Error() }
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+type TransportError struct {
+	Op  string
+	Err error
+}
+
+func (e *TransportError) Error() string { return "transport: " + e.Op + ": " + e.Err.Error() }
+func (e *TransportError) Unwrap() error { return e.Err }
+
+type ProtocolError struct {
+	Op  string
+	Err error
+}
+
+func (e *ProtocolError) Error() string { return "protocol: " + e.Op + ": " + e.Err.Error() }
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
+type ServerRejectedError struct {
+	StatusCode int
+}
+
+func (e *ServerRejectedError) Error() string {
+	return fmt.Sprintf("server rejected request: status code %d", e.StatusCode)
+}
+
+func (e *ServerRejectedError) Is(target error) bool { return target == errStatusCode }
+
+type ShutdownError struct {
+	Signal os.Signal
+}
+
+func (e *ShutdownError) Error() string { return "shutdown requested: " + e.Signal.String() }
+
+func exitCode(err error) (int, string) {
+	var (
+		configErr    *ConfigError
+		transportErr *TransportError
+		protocolErr  *ProtocolError
+		rejectedErr  *ServerRejectedError
+		shutdownErr  *ShutdownError
+	)
+	switch {
+	case err == nil, errors.Is(err, statusCode204), errors.Is(err, errSessionEnded):
+		return ExitOK, "ok"
+	case errors.As(err, &shutdownErr):
+		return ExitShutdown, "shutdown"
+	case errors.As(err, &configErr):
+		return ExitConfig, "config"
+	case errors.As(err, &rejectedErr):
+		return ExitServerRejected, "server_rejected"
+	case errors.As(err, &protocolErr):
+		return ExitProtocol, "protocol"
+	case errors.As(err, &transportErr):
+		return ExitTransport, "transport"
+	default:
+		return ExitInternal, "internal"
+	}
+}
+
+// Последняя строка в stderr перед выходом — JSON для оркестрации.
+func writeExitStatus(w io.Writer, err error) int {
+	code, kind := exitCode(err)
+	status := struct {
+		Time  string `json:"time"`
+		Code  int    `json:"exit_code"`
+		Kind  string `json:"kind"`
+		Error string `json:"error,omitempty"`
+	}{Time: time.Now().Format(time.RFC3339), Code: code, Kind: kind}
+	if err != nil && code != ExitOK {
+		status.Error = err.Error()
+	}
+	line, _ := json.Marshal(status)
+	fmt.Fprintln(w, string(line))
+	return code
+}
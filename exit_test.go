@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCodes(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, ExitOK},
+		{statusCode204, ExitOK},
+		{&ConfigError{Err: errInvalidConfig}, ExitConfig},
+		{fmt.Errorf("exchange: %w", &TransportError{Op: "post", Err: errors.New("refused")}), ExitTransport},
+		{&ProtocolError{Op: "encode requests", Err: errPayloadTooLarge}, ExitProtocol},
+		{&ServerRejectedError{StatusCode: 500}, ExitServerRejected},
+		{&ShutdownError{Signal: syscall.SIGTERM}, ExitShutdown},
+		{errors.New("boom"), ExitInternal},
+	}
+	for _, c := range cases {
+		code, _ := exitCode(c.err)
+		assert.Equal(t, c.code, code, "%v", c.err)
+	}
+
+	var buf bytes.Buffer
+	assert.Equal(t, ExitServerRejected, writeExitStatus(&buf, &ServerRejectedError{StatusCode: 503}))
+	var status map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &status))
+	assert.Equal(t, float64(ExitServerRejected), status["exit_code"])
+	assert.Equal(t, "server_rejected", status["kind"])
+	assert.Contains(t, status["error"], "503")
+}
+
+func TestSendPOSTRequestErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/reject":
+			w.WriteHeader(http.StatusBadRequest)
+		case "/garbage":
+			w.Write([]byte("!!!"))
+		case "/done":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	_, err := sendPOSTRequest(ctx, srv.URL+"/reject", nil)
+	assert.ErrorIs(t, err, errStatusCode)
+	var rejected *ServerRejectedError
+	assert.ErrorAs(t, err, &rejected)
+
+	_, err = sendPOSTRequest(ctx, srv.URL+"/garbage", nil)
+	var protocolErr *ProtocolError
+	assert.ErrorAs(t, err, &protocolErr)
+
+	_, err = sendPOSTRequest(ctx, srv.URL+"/done", nil)
+	assert.ErrorIs(t, err, statusCode204)
+
+	addr := srv.URL
+	srv.Close()
+	_, err = sendPOSTRequest(ctx, addr, nil)
+	var transportErr *TransportError
+	assert.ErrorAs(t, err, &transportErr)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+type HealthState string
+
+const (
+	HealthOnline   HealthState = "online"
+	HealthDegraded HealthState = "degraded"
+	HealthOffline  HealthState = "offline"
+	HealthRemoved  HealthState = "removed"
+)
+
+// Пороги — число подряд пропущенных ответов. По умолчанию устройство
+// удаляется после первого же пропуска, как требует протокол.
+type HealthConfig struct {
+	DegradedAfter int `yaml:"degraded_after" json:"degraded_after"`
+	OfflineAfter  int `yaml:"offline_after" json:"offline_after"`
+	RemoveAfter   int `yaml:"remove_after" json:"remove_after"`
+}
+
+func defaultHealthConfig() HealthConfig {
+	return HealthConfig{DegradedAfter: 1, OfflineAfter: 1, RemoveAfter: 1}
+}
+
+type DeviceHealth struct {
+	Address    VarUint     `json:"address"`
+	DevName    string      `json:"dev_name"`
+	State      HealthState `json:"state"`
+	LastSeen   VarUint     `json:"last_seen"`
+	Missed     int         `json:"missed"`
+	AvgLatency VarUint     `json:"avg_latency"` // мс по часам TICK
+	Responses  int         `json:"responses"`
+}
+
+type HealthTracker struct {
+	mu      sync.RWMutex
+	cfg     HealthConfig
+	devices map[VarUint]*DeviceHealth
+}
+
+func NewHealthTracker(cfg HealthConfig) *HealthTracker {
+	return &HealthTracker{cfg: cfg, devices: make(map[VarUint]*DeviceHealth)}
+}
+
+func (t *HealthTracker) SetConfig(cfg HealthConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfg = cfg
+}
+
+func (t *HealthTracker) record(address VarUint, name string) *DeviceHealth {
+	dh, ok := t.devices[address]
+	if !ok {
+		dh = &DeviceHealth{Address: address, State: HealthOnline}
+		t.devices[address] = dh
+	}
+	if name != "" {
+		dh.DevName = name
+	}
+	return dh
+}
+
+// Seen — от устройства пришёл пакет. Возвращает прежнее и новое состояние.
+func (t *HealthTracker) Seen(address VarUint, name string, now VarUint) (HealthState, HealthState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dh := t.record(address, name)
+	old := dh.State
+	dh.LastSeen = now
+	dh.Missed = 0
+	dh.State = HealthOnline
+	return old, dh.State
+}
+
+func (t *HealthTracker) Response(address VarUint, latency VarUint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dh := t.record(address, "")
+	dh.Responses++
+	// скользящее среднее, новое значение с весом 1/8
+	if dh.Responses == 1 {
+		dh.AvgLatency = latency
+	} else {
+		dh.AvgLatency = (dh.AvgLatency*7 + latency) / 8
+	}
+}
+
+// Missed — истёк таймаут ответа. Возвращает прежнее и новое состояние.
+func (t *HealthTracker) Missed(address VarUint) (HealthState, HealthState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dh := t.record(address, "")
+	old := dh.State
+	if old == HealthRemoved {
+		return old, old
+	}
+	dh.Missed++
+	switch {
+	case dh.Missed >= t.cfg.RemoveAfter:
+		dh.State = HealthRemoved
+	case dh.Missed >= t.cfg.OfflineAfter:
+		dh.State = HealthOffline
+	case dh.Missed >= t.cfg.DegradedAfter:
+		dh.State = HealthDegraded
+	}
+	return old, dh.State
+}
+
+func (t *HealthTracker) Removed(address VarUint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if dh, ok := t.devices[address]; ok {
+		dh.State = HealthRemoved
+	}
+}
+
+func (t *HealthTracker) Get(address VarUint) (DeviceHealth, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	dh, ok := t.devices[address]
+	if !ok {
+		return DeviceHealth{}, false
+	}
+	return *dh, true
+}
+
+func (t *HealthTracker) All() []DeviceHealth {
+	t.mu.RLock()
+	all := make([]DeviceHealth, 0, len(t.devices))
+	for _, dh := range t.devices {
+		all = append(all, *dh)
+	}
+	t.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].Address < all[j].Address })
+	return all
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceHealth(t *testing.T) {
+	hub := newTestHub()
+	hub.wr = CreateWaitRequests()
+	hub.health.SetConfig(HealthConfig{DegradedAfter: 1, OfflineAfter: 2, RemoveAfter: 3})
+	sub := hub.Events().Subscribe(16, DropNewest, func(e Event) bool { return e.Type == EventHealthChanged })
+	hub.SaveDevice("LAMP01", 5, LAMP, nil)
+
+	state := func() HealthState {
+		dh, ok := hub.health.Get(5)
+		require.True(t, ok)
+		return dh.State
+	}
+	probes := func() int {
+		n := 0
+		for _, p := range hub.requests.GetAllAndClear() {
+			if p.Cmd == GETSTATUS && p.Dst == 5 {
+				n++
+			}
+		}
+		return n
+	}
+
+	hub.processingPayload(tick(100))
+	assert.Equal(t, HealthOnline, state())
+	hub.SetDeviceStatus("LAMP01", true)
+	hub.requests.GetAllAndClear()
+	hub.processingPayload(tick(200))
+	hub.processingPayload(tick(500))
+	assert.Equal(t, HealthDegraded, state())
+	assert.Equal(t, 1, probes())
+
+	hub.processingPayload(tick(600))
+	hub.processingPayload(tick(900))
+	assert.Equal(t, HealthOffline, state())
+	assert.Equal(t, 1, probes())
+
+	// вернулся до порога удаления
+	hub.processingPayload(tick(1000))
+	hub.processingPayload(tick(1100))
+	hub.processingPayload(Payload{Src: 5, Dst: 1, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(true)})
+	dh, _ := hub.health.Get(5)
+	assert.Equal(t, HealthOnline, dh.State)
+	assert.Equal(t, VarUint(1100), dh.LastSeen)
+	assert.Equal(t, VarUint(100), dh.AvgLatency)
+	assert.Zero(t, dh.Missed)
+	_, ok := hub.DeviceByName("LAMP01")
+	assert.True(t, ok)
+
+	hub.SetDeviceStatus("LAMP01", false)
+	for ts := VarUint(1200); ts <= 2300; ts += 100 {
+		hub.processingPayload(tick(ts))
+		hub.requests.GetAllAndClear()
+	}
+	assert.Equal(t, HealthRemoved, state())
+	_, ok = hub.DeviceByName("LAMP01")
+	assert.False(t, ok)
+
+	var states []HealthState
+	for len(sub.C) > 0 {
+		states = append(states, (<-sub.C).Health.State)
+	}
+	assert.Equal(t, []HealthState{HealthDegraded, HealthOffline, HealthOnline, HealthDegraded, HealthOffline, HealthRemoved}, states)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errDeviceNotFound = errors.New("device not found")
+
+// Start запускает две горутины: сетевую (кодирование, отправка, приём)
+// и обработчик ответов. Остальные горутины (API, планировщик, метрики)
+// работают с хабом через методы, которые берут h.mu.
+func (h *Hub) Start(ctx context.Context) error {
+	h.mu.Lock()
+	if h.transport == nil {
+		cfg := defaultTransportConfig()
+		if h.cfg != nil {
+			cfg = h.cfg.Transport
+		}
+		t, err := NewTransport(h.Url, cfg)
+		if err != nil {
+			h.mu.Unlock()
+			return &ConfigError{Err: err}
+		}
+		defer t.Close()
+		h.transport = h.retrying(t)
+	}
+	h.requests.Push(ClassDiscovery, createWhoIsHereRequest(h))
+	h.wr.Add(CreateWaitRequest(2, 1e10))
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	responses := make(chan []Payload)
+	processed := make(chan struct{})
+	errc := make(chan error, 2)
+	go func() { errc <- h.runNetwork(ctx, responses, processed) }()
+	go func() { errc <- h.runProcessing(ctx, responses, processed) }()
+	err := <-errc
+	cancel()
+	<-errc
+	return err
+}
+
+// Следующий запрос уходит только после обработки предыдущего ответа,
+// чтобы в него попали реакции на этот ответ.
+func (h *Hub) runNetwork(ctx context.Context, responses chan<- []Payload, processed <-chan struct{}) error {
+	for {
+		h.mu.Lock()
+		batch := h.requests.Next()
+		h.mu.Unlock()
+		response, err := h.transport.Exchange(ctx, batch)
+		if err != nil {
+			return err
+		}
+		select {
+		case responses <- response:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-processed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (h *Hub) runProcessing(ctx context.Context, responses <-chan []Payload, processed chan<- struct{}) error {
+	for {
+		select {
+		case response := <-responses:
+			h.mu.Lock()
+			for _, val := range response {
+				h.processingPayload(val)
+			}
+			h.mu.Unlock()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case processed <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (h *Hub) Devices() []Device {
+	return h.registry.All()
+}
+
+func (h *Hub) DeviceByName(name string) (Device, bool) {
+	return h.registry.ByName(name)
+}
+
+// Повторяются только ошибки транспорта: 204 и коды ошибок — это решение
+// сервера, а не сбой сети.
+func (h *Hub) retrying(t Transport) Transport {
+	return ExchangeFunc(func(ctx context.Context, requests []Payload) ([]Payload, error) {
+		attempts, backoff := 0, time.Duration(0)
+		h.mu.Lock()
+		if h.cfg != nil {
+			attempts, backoff = h.cfg.Retry.Attempts, time.Duration(h.cfg.Retry.Backoff)
+		}
+		h.mu.Unlock()
+		for i := 0; ; i++ {
+			payloads, err := t.Exchange(ctx, requests)
+			var transportErr *TransportError
+			if err == nil || i >= attempts || !errors.As(err, &transportErr) {
+				return payloads, err
+			}
+			logf(LogWarn, "exchange failed (attempt %d of %d): %v", i+1, attempts+1, err)
+			select {
+			case <-time.After(backoff * time.Duration(i+1)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	})
+}
+
+// SetDeviceStatus ставит SETSTATUS в очередь; уйдёт со следующим запросом.
+func (h *Hub) SetDeviceStatus(name string, on bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dev, ok := h.registry.ByName(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", errDeviceNotFound, name)
+	}
+	_, err := h.pushSetStatus(ClassUser, dev, on)
+	return err
+}
+
+// pushSetStatus возвращает true, если эта команда уйдёт устройству: встала
+// в очередь или заменила стоявшую там. Без повода (состояние уже такое,
+// команда слита со стоящей или отброшена ограничением) — false.
+func (h *Hub) pushSetStatus(class RequestClass, dev Device, on bool) (bool, error) {
+	body, err := lookupDeviceType(dev.DevType).EncodeSetStatus(on)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", dev.DevName, err)
+	}
+	// устройство уже в нужном состоянии, и других команд ему не ждём
+	if known, ok := dev.Status.(Flag); ok && bool(known) == on &&
+		!h.requests.Queued(SETSTATUS, dev.Address) && !h.wr.Pending(STATUS, dev.Address) {
+		h.metrics.Inc("smarthub_setstatus_skipped_total")
+		return false, nil
+	}
+	h.Serial++
+	queued, err := h.enqueue(class, Payload{
+		Src:     h.Address,
+		Dst:     dev.Address,
+		Serial:  h.Serial,
+		DevType: dev.DevType,
+		Cmd:     SETSTATUS,
+		CmdBody: body,
+	})
+	if queued {
+		h.wr.Add(CreateWaitRequest(STATUS, dev.Address))
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// ответа на заменённую команду уже ждут
+	queuedCmd, ok := h.requests.Lookup(SETSTATUS, dev.Address)
+	return ok && queuedCmd.Serial == h.Serial, nil
+}
+
+func (h *Hub) pushGetStatus(dev Device) {
+	h.Serial++
+	if queued, _ := h.enqueue(ClassPolling, Payload{
+		Src:     h.Address,
+		Dst:     dev.Address,
+		Serial:  h.Serial,
+		DevType: dev.DevType,
+		Cmd:     GETSTATUS,
+	}); queued {
+		h.wr.Add(CreateWaitRequest(STATUS, dev.Address))
+	}
+}
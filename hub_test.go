@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// сервер-заглушка: отвечает заранее заготовленными пакетами, затем 204
+func scriptedExchange(script [][]Payload, sent *[]Payload) Transport {
+	var mu sync.Mutex
+	step := 0
+	return ExchangeFunc(func(ctx context.Context, requests []Payload) ([]Payload, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		*sent = append(*sent, requests...)
+		time.Sleep(time.Millisecond)
+		if step >= len(script) {
+			return nil, statusCode204
+		}
+		step++
+		return script[step-1], nil
+	})
+}
+
+func tick(ts VarUint) Payload {
+	return Payload{Src: 6, Dst: ALL, Serial: ts, DevType: CLOCK, Cmd: TICK, CmdBody: TimerСmdBody{Timestamp: ts}}
+}
+
+func TestHubConcurrentAccess(t *testing.T) {
+	script := [][]Payload{
+		{{Src: 5, Dst: ALL, Serial: 1, DevType: LAMP, Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: "LAMP01"}}},
+	}
+	for ts := VarUint(1000); ts < 1200; ts += 10 {
+		script = append(script, []Payload{
+			tick(ts),
+			{Src: 5, Dst: 1, Serial: ts, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(ts%20 == 0)},
+		})
+	}
+	var sent []Payload
+	hub := newTestHub()
+	hub.requests = NewScheduler()
+	hub.wr = CreateWaitRequests()
+	hub.transport = scriptedExchange(script, &sent)
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				hub.Devices()
+				if _, ok := hub.DeviceByName("LAMP01"); ok {
+					hub.SetDeviceStatus("LAMP01", true)
+				}
+				time.Sleep(100 * time.Microsecond)
+			}
+		}()
+	}
+	err := hub.Start(context.Background())
+	stop.Store(true)
+	wg.Wait()
+
+	assert.ErrorIs(t, err, statusCode204)
+	assert.Len(t, hub.Devices(), 1)
+	setStatus := 0
+	for _, p := range sent {
+		if p.Cmd == SETSTATUS && p.Dst == 5 {
+			setStatus++
+		}
+	}
+	assert.Greater(t, setStatus, 0)
+}
+
+func TestHubStartCancel(t *testing.T) {
+	hub := newTestHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	hub.transport = ExchangeFunc(func(ctx context.Context, requests []Payload) ([]Payload, error) {
+		cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.ErrorIs(t, hub.Start(ctx), context.Canceled)
+}
+
+func TestWhoIsHereFromOutOfRangeSrc(t *testing.T) {
+	bad := Payload{Src: ALL + 1, Dst: ALL, Serial: 1, DevType: SWITCH, Cmd: WHOISHERE, CmdBody: DeviceCmdBody{DevName: "BAD01"}}
+	// кодировщик такой пакет не соберёт, кадр собирается вручную
+	bin := AppendPayload(nil, bad)
+	frame := append(append([]byte{byte(len(bin))}, bin...), calculateCRC8(bin))
+	assert.Empty(t, deserializeFromBinaryFormToPayloads(frame))
+
+	// даже дойдя до обработки, он не останавливает хаб
+	var sent []Payload
+	hub := newTestHub()
+	hub.transport = scriptedExchange([][]Payload{{bad}}, &sent)
+	assert.ErrorIs(t, hub.Start(context.Background()), statusCode204)
+	for _, p := range sent {
+		assert.LessOrEqual(t, p.Dst, ALL)
+	}
+
+	// пакет, который не кодируется, пропускается, остальные уходят
+	var got []Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = decodeBase64ToPayloads(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	tr, err := NewTransport(srv.URL, defaultTransportConfig())
+	require.NoError(t, err)
+	good := Payload{Src: 1, Dst: 5, Serial: 3, DevType: LAMP, Cmd: GETSTATUS}
+	_, err = tr.Exchange(context.Background(), []Payload{
+		{Src: 1, Dst: ALL + 1, Serial: 2, DevType: SMARTHUB, Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: "HUB00"}},
+		good,
+	})
+	assert.ErrorIs(t, err, statusCode204)
+	assert.Equal(t, []Payload{good}, got)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var errUnknownLogLevel = errors.New("unknown log level")
+
+var logLevelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return "unknown"
+	}
+	return logLevelNames[l]
+}
+
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", errUnknownLogLevel, s)
+}
+
+var (
+	logLevel   atomic.Int32
+	logJSON    atomic.Bool
+	logger     = log.New(os.Stderr, "", log.LstdFlags)
+	logOutFile io.Closer
+)
+
+func setupLogging(cfg LogConfig) error {
+	level, err := ParseLogLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = os.Stderr
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		if logOutFile != nil {
+			logOutFile.Close()
+		}
+		out, logOutFile = f, f
+	}
+	logLevel.Store(int32(level))
+	logJSON.Store(cfg.Format == "json")
+	if cfg.Format == "json" {
+		logger.SetFlags(0)
+	} else {
+		logger.SetFlags(log.LstdFlags)
+	}
+	logger.SetOutput(out)
+	return nil
+}
+
+func logf(level LogLevel, format string, args ...any) {
+	if int32(level) < logLevel.Load() {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if logJSON.Load() {
+		line, _ := json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{time.Now().Format(time.RFC3339), level.String(), msg})
+		logger.Print(string(line))
+		return
+	}
+	logger.Printf("%s: %s", level, msg)
+}
+
+func init() {
+	logLevel.Store(int32(LogInfo))
+}
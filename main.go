@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"sync"
+	"syscall"
 )
 
 const ( // cmd
@@ -18,6 +22,22 @@ const ( // cmd
 	TICK      byte = 0x06
 )
 
+var cmdNames = map[byte]string{
+	WHOISHERE: "WHOISHERE",
+	IAMHERE:   "IAMHERE",
+	GETSTATUS: "GETSTATUS",
+	STATUS:    "STATUS",
+	SETSTATUS: "SETSTATUS",
+	TICK:      "TICK",
+}
+
+func CmdName(cmd byte) string {
+	if name, ok := cmdNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("cmd(%#x)", cmd)
+}
+
 const ( //dev_type
 	SMARTHUB  byte = 0x01
 	ENVSENSOR byte = 0x02
@@ -74,93 +94,123 @@ type Packet struct {
 }
 
 type EnvSensorStatusCmdBody struct {
-	Values []VarUint //температура-влажность-освещенность-загрязнение воздуха
+	Values []VarUint `json:"values"` //температура-влажность-освещенность-загрязнение воздуха
 }
 
 type Flag bool
 
 type Hub struct {
-	Name               string
-	Url                string
-	Address            VarUint
-	DevicesWithAddress map[VarUint]Device
-	DevicesWithName    map[string]Device
-	Serial             VarUint
-	wr                 waitRequests
-	importantRequests  QueueRequests
-	requests           QueueRequests
+	Name          string
+	Url           string
+	Address       VarUint
+	Serial        VarUint
+	registry      *Registry
+	events        *EventBus
+	health        *HealthTracker
+	metrics       *Metrics
+	limiter       *RateLimiter
+	now           VarUint
+	cfg           *Config
+	loadConfig    func() (*Config, error)
+	scheduleLast  map[string]VarUint
+	pollNext      map[VarUint]VarUint
+	sweep         *sweep
+	discoveryNext VarUint
+	lastDiscovery *DiscoveryDiff
+	wr            waitRequests
+	requests      *Scheduler
+
+	// mu защищает всё состояние выше; сеть, обработка и API работают
+	// в разных горутинах
+	mu        sync.Mutex
+	transport Transport
 }
 
 type Device struct {
-	DevName string
-	Address VarUint
-	DevType byte
-	Body    Serializer
+	DevName string     `json:"dev_name"`
+	Address VarUint    `json:"address"`
+	DevType byte       `json:"dev_type"`
+	Props   Serializer `json:"props,omitempty"`
+	Status  Serializer `json:"status,omitempty"`
+	// имя или адрес оспаривается другим устройством, см. Registry
+	Conflict bool `json:"conflict,omitempty"`
 }
 
-func newDevice(name string, address VarUint, devType byte, body Serializer) Device {
+func (d Device) TypeName() string {
+	return DeviceTypeName(d.DevType)
+}
+
+func newDevice(name string, address VarUint, devType byte, props Serializer) Device {
 	return Device{
 		DevName: name,
 		Address: address,
 		DevType: devType,
-		Body:    body,
+		Props:   props,
 	}
 }
 
-func CreateHub() (Hub, error) {
-	args := os.Args
-	if len(args) < 3 {
-		return Hub{}, errors.New("arg(s) from cmd not finded")
+func newHub(name, url string, address VarUint) *Hub {
+	h := &Hub{
+		Name:     name,
+		Url:      url,
+		Address:  address,
+		Serial:   0,
+		registry: NewRegistry(),
+		events:   NewEventBus(),
+		health:   NewHealthTracker(defaultHealthConfig()),
+		metrics:  NewMetrics(),
+		limiter:  NewRateLimiter(),
+		wr:       CreateWaitRequests(),
+		requests: NewScheduler(),
 	}
-	strAdr, err := ConvertInt(args[2], 16, 10)
-	if err != nil {
-		return Hub{}, errors.New("can't read address of hub")
-	}
-	adr, _ := strconv.Atoi(strAdr)
-	return Hub{
-		Name:               "HUB00",
-		Url:                args[1],
-		Address:            VarUint(adr),
-		DevicesWithAddress: make(map[VarUint]Device),
-		DevicesWithName:    make(map[string]Device),
-		Serial:             0,
-		wr:                 CreateWaitRequests(),
-		importantRequests:  newQueue(),
-		requests:           newQueue(),
-	}, nil
-}
-
-func ConvertInt(val string, base, toBase int) (string, error) {
-	i, err := strconv.ParseInt(val, base, 64)
-	if err != nil {
-		return "", err
+	h.registry.Watch(func(c RegistryChange) {
+		switch c.Kind {
+		case DeviceConflict:
+			logf(LogWarn, "%s conflict: %s", c.Conflict.Kind, describeConflict(c.Conflict))
+		case DeviceAdded, DeviceUpdated:
+			h.health.Seen(c.Device.Address, c.Device.DevName, h.now)
+		case DeviceRemoved:
+			h.health.Removed(c.Device.Address)
+			h.limiter.Forget(c.Device.Address)
+		}
+		h.publish(registryEvent(c))
+	})
+	// вытесненному пакету ответа уже не будет
+	h.requests.OnDrop = func(class RequestClass, p Payload) {
+		logf(LogWarn, "%s queue full: dropped %s to %#x", class, CmdName(p.Cmd), p.Dst)
+		if p.Cmd == GETSTATUS || p.Cmd == SETSTATUS {
+			h.takeWaitRequest(STATUS, p.Dst)
+		}
 	}
-	return strconv.FormatInt(i, toBase), nil
+	return h
 }
 
-func (h *Hub) Start() error {
+func (h *Hub) publish(e Event) {
+	if h.events == nil {
+		return
+	}
+	e.Tick = h.now
+	h.events.Publish(e)
+}
 
-	h.importantRequests.Push(createWhoIsHereRequest(h))
-	h.wr.Add(CreateWaitRequest(2, 1e10))
-	for {
-		var requests []Payload
-		if h.importantRequests.size > 0 {
-			requests = []Payload{h.importantRequests.GetAndPop()}
-		} else {
-			requests = h.requests.GetAllAndClear()
-		}
-		strBase64 := serializePayloadsToBase64URLEncoded(requests)
-		response, err := sendPOSTRequest(h.Url, strBase64)
-		if err != nil {
-			return err
-		}
-		for _, val := range response {
-			h.processingPayload(val)
-		}
-		if h.importantRequests.size == 0 && h.requests.size == 0 {
-			h.requests.Push(Payload{})
+func (h *Hub) Events() *EventBus {
+	return h.events
+}
+
+func NewHubFromConfig(cfg *Config) (*Hub, error) {
+	h := newHub(cfg.Name, cfg.URL, cfg.address)
+	h.cfg = cfg
+	h.wr.timeout = cfg.ResponseTimeout
+	h.registry.SetPolicy(cfg.ConflictPolicy)
+	h.requests.SetConfig(cfg.Scheduler)
+	h.limiter.SetConfig(cfg.RateLimit)
+	h.health.SetConfig(cfg.Health)
+	if cfg.PersistPath != "" {
+		if err := loadDevices(cfg.PersistPath, h.registry); err != nil {
+			return nil, fmt.Errorf("load devices: %w", err)
 		}
 	}
+	return h, nil
 }
 
 func createWhoIsHereRequest(h *Hub) Payload {
@@ -177,37 +227,91 @@ func createWhoIsHereRequest(h *Hub) Payload {
 	}
 }
 
-func sendPOSTRequest(url string, base64String string) ([]Payload, error) {
-	resp, err := http.Post(url, "application/base64", bytes.NewBufferString(base64String))
+func sendPOSTRequest(ctx context.Context, url string, body []byte) ([]Payload, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return nil, err
+		return nil, &ConfigError{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/base64")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &TransportError{Op: "post", Err: err}
 	}
 	defer resp.Body.Close()
 	switch resp.StatusCode {
 	case 200:
-		body, _ := io.ReadAll(resp.Body)
-		payloads := decodeBase64ToPayloads(body)
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, &TransportError{Op: "read response", Err: err}
+		}
+		payloads, err := decodeBase64Payloads(body)
+		if err != nil {
+			return nil, &ProtocolError{Op: "decode response", Err: err}
+		}
 		return payloads, nil
 	case 204:
 		return nil, statusCode204
 	default:
-		return nil, errStatusCode
+		return nil, &ServerRejectedError{StatusCode: resp.StatusCode}
 	}
 }
 
 func main() {
-	hub, err := CreateHub()
+	os.Exit(writeExitStatus(os.Stderr, run()))
+}
+
+func run() error {
+	cfg, err := LoadConfig(os.Args[1:], os.Getenv)
 	if err != nil {
-		os.Exit(99)
+		return &ConfigError{Err: err}
 	}
-	err = hub.Start()
+	if err := setupLogging(cfg.Log); err != nil {
+		return &ConfigError{Err: err}
+	}
+	hub, err := NewHubFromConfig(cfg)
 	if err != nil {
-		if errors.Is(err, statusCode204) {
-			os.Exit(0)
-		} else if errors.Is(err, errStatusCode) {
-			os.Exit(99)
-		} else {
-			os.Exit(99)
+		return &ConfigError{Err: err}
+	}
+	hub.loadConfig = func() (*Config, error) {
+		return LoadConfig(os.Args[1:], os.Getenv)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		select {
+		case sig := <-stop:
+			logf(LogInfo, "received %s, stopping", sig)
+			cancel(&ShutdownError{Signal: sig})
+		case <-ctx.Done():
 		}
+	}()
+
+	hub.WatchConfig(ctx, configPollInterval)
+	if cfg.APIListen != "" {
+		go func() {
+			if err := hub.ServeAPI(ctx, cfg.APIListen); err != nil {
+				logf(LogError, "api: %v", err)
+			}
+		}()
+	}
+	if cfg.MQTT.Broker != "" {
+		go hub.RunMQTT(ctx, cfg.MQTT)
+	}
+	err = hub.Start(ctx)
+	if cfg.PersistPath != "" {
+		if err := saveDevices(cfg.PersistPath, hub.Devices()); err != nil {
+			logf(LogError, "save devices: %v", err)
+		}
+	}
+	if cause := context.Cause(ctx); errors.Is(err, context.Canceled) && cause != nil {
+		return cause
 	}
+	return err
 }
@@ -8,15 +8,15 @@ import (
 
 func TestEnvSensor(t *testing.T) {
 	hub := Hub{
-		Name:               "HUB00",
-		Url:                "dsafd",
-		Address:            VarUint(1),
-		DevicesWithAddress: make(map[VarUint]Device),
-		DevicesWithName:    make(map[string]Device),
-		Serial:             0,
-		wr:                 CreateWaitRequests(),
-		importantRequests:  newQueue(),
-		requests:           newQueue(),
+		Name:     "HUB00",
+		Url:      "dsafd",
+		Address:  VarUint(1),
+		registry: NewRegistry(),
+		health:   NewHealthTracker(defaultHealthConfig()),
+		Serial:   0,
+		wr:       CreateWaitRequests(),
+		requests: NewScheduler(),
+		limiter:  NewRateLimiter(),
 	}
 	hub.wr.Add(CreateWaitRequest(2, 1e10))
 	payloads := decodeBase64ToPayloads([]byte("OAL_fwQCAghTRU5TT1IwMQ8EDGQGT1RIRVIxD7AJBk9USEVSMgCsjQYGT1RIRVIzCAAGT1RIRVI09w"))
@@ -61,7 +61,112 @@ func TestEnvSensor(t *testing.T) {
 	payloads = decodeBase64ToPayloads([]byte("EQIBBgIEBKUB4AfUjgaMjfILrw"))
 	hub.processingPayload(payloads[0])
 	buf := new(bytes.Buffer)
-	serializePayload(buf, hub.requests.data[2])
+	serializePayload(buf, hub.requests.Queue(ClassAutomation).At(1))
 	ser := buf.Bytes()
 	assert.Equal(t, ser[len(ser)-1], byte(0x01))
 }
+
+func newTestHub() *Hub {
+	hub := newHub("HUB00", "", 1)
+	hub.wr.Add(CreateWaitRequest(2, 1e10))
+	return hub
+}
+
+func encodePayloads(t *testing.T, payloads ...Payload) []byte {
+	str, err := serializePayloadsToBase64URLEncoded(payloads)
+	assert.NoError(t, err)
+	return []byte(str)
+}
+
+func TestUnknownDeviceType(t *testing.T) {
+	hub := newTestHub()
+	payloads := decodeBase64ToPayloads(encodePayloads(t,
+		Payload{Src: 7, Dst: ALL, Serial: 1, DevType: 0x42, Cmd: IAMHERE, CmdBody: DeviceCmdBody{
+			DevName:  "ROBOT01",
+			DevProps: RawBody{0x01, 0x02, 0x03},
+		}},
+		Payload{Src: 7, Dst: 1, Serial: 2, DevType: 0x42, Cmd: 0x10, CmdBody: RawBody{0xAA}},
+	))
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, RawBody{0xAA}, payloads[1].CmdBody)
+	for _, p := range payloads {
+		hub.processingPayload(p)
+	}
+	dev, ok := hub.registry.ByName("ROBOT01")
+	assert.True(t, ok)
+	assert.Equal(t, "unknown", dev.TypeName())
+	assert.Equal(t, RawBody{0x01, 0x02, 0x03}, dev.Props)
+}
+
+type thermostatType struct {
+	handled *Serializer
+}
+
+func (thermostatType) Code() byte                 { return 0x43 }
+func (thermostatType) Name() string               { return "Thermostat" }
+func (thermostatType) Capabilities() Capabilities { return Capabilities{GetStatus: true} }
+
+func (thermostatType) DecodeProps(bin []byte) (Serializer, error) { return nil, nil }
+
+func (thermostatType) DecodeStatus(bin []byte) (Serializer, error) {
+	value, _, err := ReadVarUint(bin)
+	return value, err
+}
+
+func (thermostatType) EncodeSetStatus(on bool) (Serializer, error) {
+	return nil, errSetStatusUnsupported
+}
+
+func (t thermostatType) HandleStatus(h *Hub, device Device, status Serializer) {
+	*t.handled = status
+}
+
+func unregisterDeviceType(code byte) {
+	deviceTypesMu.Lock()
+	defer deviceTypesMu.Unlock()
+	delete(deviceTypes, code)
+}
+
+func TestRegisteredDeviceType(t *testing.T) {
+	var handled Serializer
+	thermostat := thermostatType{handled: &handled}
+	assert.NoError(t, RegisterDeviceType(thermostat))
+	t.Cleanup(func() { unregisterDeviceType(thermostat.Code()) })
+	assert.ErrorIs(t, RegisterDeviceType(thermostat), errDeviceTypeRegistered)
+	assert.ErrorIs(t, RegisterDeviceType(lampType{}), errDeviceTypeRegistered)
+
+	hub := newTestHub()
+	payloads := decodeBase64ToPayloads(encodePayloads(t,
+		Payload{Src: 8, Dst: ALL, Serial: 1, DevType: thermostat.Code(), Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: "THERM01"}},
+		Payload{Src: 8, Dst: 1, Serial: 2, DevType: thermostat.Code(), Cmd: STATUS, CmdBody: VarUint(215)},
+	))
+	for _, p := range payloads {
+		hub.processingPayload(p)
+	}
+	assert.Equal(t, "Thermostat", hub.Devices()[0].TypeName())
+	assert.Equal(t, VarUint(215), handled)
+}
+
+func TestRegisterDeviceTypeWhileRunning(t *testing.T) {
+	var handled Serializer
+	thermostat := thermostatType{handled: &handled}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			lookupDeviceType(thermostat.Code())
+			lookupDeviceTypeByName("thermostat")
+		}
+	}()
+	assert.NoError(t, RegisterDeviceType(thermostat))
+	t.Cleanup(func() { unregisterDeviceType(thermostat.Code()) })
+	close(stop)
+	<-done
+	assert.Equal(t, "Thermostat", lookupDeviceType(thermostat.Code()).Name())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metrics — счётчики и датчики в текстовом формате Prometheus. Метка
+// задаётся парами имя, значение.
+type Metrics struct {
+	mu     sync.Mutex
+	kinds  map[string]string
+	values map[string]map[string]float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{kinds: make(map[string]string), values: make(map[string]map[string]float64)}
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (m *Metrics) series(kind, name string, labels []string) (map[string]float64, string) {
+	m.kinds[name] = kind
+	series, ok := m.values[name]
+	if !ok {
+		series = make(map[string]float64)
+		m.values[name] = series
+	}
+	return series, formatLabels(labels)
+}
+
+func (m *Metrics) Add(name string, delta float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, key := m.series("counter", name, labels)
+	series[key] += delta
+}
+
+func (m *Metrics) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+func (m *Metrics) Set(name string, value float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, key := m.series("gauge", name, labels)
+	series[key] = value
+}
+
+func (m *Metrics) Value(name string, labels ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[name][formatLabels(labels)]
+}
+
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.values))
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, m.kinds[name])
+		keys := make([]string, 0, len(m.values[name]))
+		for key := range m.values[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s%s %g\n", name, key, m.values[name][key])
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Датчики, которые дешевле снять в момент запроса, чем обновлять на ходу.
+func (h *Hub) collectMetrics() {
+	h.mu.Lock()
+	for class := RequestClass(0); class < numRequestClasses; class++ {
+		q := h.requests.Queue(class)
+		h.metrics.Set("smarthub_queue_depth", float64(q.Len()), "class", class.String())
+		h.metrics.Set("smarthub_queue_high_water", float64(q.HighWater()), "class", class.String())
+		h.metrics.Set("smarthub_queue_capacity", float64(q.Capacity()), "class", class.String())
+		h.metrics.Set("smarthub_queue_overflow", float64(q.Dropped()), "class", class.String())
+	}
+	h.metrics.Set("smarthub_requests_coalesced", float64(h.requests.Dropped()))
+	h.metrics.Set("smarthub_wait_requests", float64(h.wr.size))
+	h.metrics.Set("smarthub_tick", float64(h.now))
+	h.metrics.Set("smarthub_packets_skipped", float64(skippedPackets.Load()))
+	h.mu.Unlock()
+	h.metrics.Set("smarthub_devices", float64(h.registry.Len()))
+}
+
+func (h *Hub) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	h.collectMetrics()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	h.metrics.WriteTo(w)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Минимальный клиент MQTT 3.1.1 для моста: CONNECT, PUBLISH с QoS 0,
+// SUBSCRIBE и PINGREQ. Входящие PUBLISH с QoS 1 подтверждаются, QoS 2
+// не поддерживается.
+const (
+	mqttConnect    byte = 1
+	mqttConnAck    byte = 2
+	mqttPublish    byte = 3
+	mqttPubAck     byte = 4
+	mqttSubscribe  byte = 8
+	mqttSubAck     byte = 9
+	mqttPingReq    byte = 12
+	mqttPingResp   byte = 13
+	mqttDisconnect byte = 14
+
+	mqttMaxRemaining = 268435455
+)
+
+var (
+	errMQTTProtocol  = errors.New("mqtt protocol error")
+	errMQTTRefused   = errors.New("mqtt connection refused")
+	errMQTTSubscribe = errors.New("mqtt subscription refused")
+)
+
+var mqttConnAckCodes = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+type mqttClient struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	keepAlive time.Duration
+
+	wmu    sync.Mutex // Publish, Subscribe и Ping зовутся из разных горутин
+	wbuf   []byte
+	nextID uint16
+}
+
+// dialMQTT подключается к брокеру tcp://host:port (или mqtt://) и ждёт CONNACK.
+func dialMQTT(ctx context.Context, cfg MQTTConfig, clientID string) (*mqttClient, error) {
+	u, err := url.Parse(cfg.Broker)
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Timeout: time.Duration(cfg.DialTimeout)}
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttClient{conn: conn, r: bufio.NewReader(conn), keepAlive: time.Duration(cfg.KeepAlive)}
+
+	flags := byte(0x02) // clean session
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4) // 3.1.1
+	if cfg.Username != "" {
+		flags |= 0x80
+	}
+	if cfg.Password != "" {
+		flags |= 0x40
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(c.keepAlive/time.Second))
+	body = appendMQTTString(body, clientID)
+	if cfg.Username != "" {
+		body = appendMQTTString(body, cfg.Username)
+	}
+	if cfg.Password != "" {
+		body = appendMQTTString(body, cfg.Password)
+	}
+	if err := c.write(mqttConnect<<4, body); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Duration(cfg.DialTimeout)))
+	header, ack, err := readMQTTPacket(c.r)
+	if err == nil && (header>>4 != mqttConnAck || len(ack) != 2) {
+		err = fmt.Errorf("%w: expected CONNACK, got type %d", errMQTTProtocol, header>>4)
+	}
+	if err == nil && ack[1] != 0 {
+		reason, ok := mqttConnAckCodes[ack[1]]
+		if !ok {
+			reason = fmt.Sprintf("code %d", ack[1])
+		}
+		err = fmt.Errorf("%w: %s", errMQTTRefused, reason)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	return c, nil
+}
+
+func appendMQTTString(dst []byte, s string) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(s)))
+	return append(dst, s...)
+}
+
+func readMQTTString(b []byte) (string, []byte, error) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return "", nil, fmt.Errorf("%w: short string", errMQTTProtocol)
+	}
+	n := 2 + int(binary.BigEndian.Uint16(b))
+	return string(b[2:n]), b[n:], nil
+}
+
+// Оставшаяся длина — varint по 7 бит, младшие группы первыми, не больше 4 байт.
+func appendMQTTPacket(dst []byte, header byte, body []byte) []byte {
+	dst = append(dst, header)
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		dst = append(dst, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(dst, body...)
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7F) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, fmt.Errorf("%w: malformed remaining length", errMQTTProtocol)
+		}
+		mult *= 128
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func (c *mqttClient) write(header byte, body []byte) error {
+	if len(body) > mqttMaxRemaining {
+		return fmt.Errorf("%w: %d bytes", errMessageTooLarge, len(body))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.wbuf = appendMQTTPacket(c.wbuf[:0], header, body)
+	if c.keepAlive > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.keepAlive))
+	}
+	_, err := c.conn.Write(c.wbuf)
+	return err
+}
+
+func (c *mqttClient) Publish(topic string, payload []byte, retain bool) error {
+	header := mqttPublish << 4
+	if retain {
+		header |= 0x01
+	}
+	body := append(appendMQTTString(nil, topic), payload...)
+	return c.write(header, body)
+}
+
+// Subscribe отправляет SUBSCRIBE с QoS 0; отказ брокера вернёт ReadMessage.
+func (c *mqttClient) Subscribe(filter string) error {
+	c.wmu.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID++
+	}
+	id := c.nextID
+	c.wmu.Unlock()
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendMQTTString(body, filter)
+	body = append(body, 0)
+	return c.write(mqttSubscribe<<4|0x02, body)
+}
+
+func (c *mqttClient) Ping() error {
+	return c.write(mqttPingReq<<4, nil)
+}
+
+// ReadMessage ждёт следующий PUBLISH от брокера. Вызывается из одной
+// горутины; если за полтора keepalive от брокера ничего не пришло
+// (даже PINGRESP), соединение считается потерянным.
+func (c *mqttClient) ReadMessage() (mqttMessage, error) {
+	for {
+		if c.keepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		}
+		header, body, err := readMQTTPacket(c.r)
+		if err != nil {
+			return mqttMessage{}, err
+		}
+		switch header >> 4 {
+		case mqttPublish:
+			qos := header >> 1 & 0x03
+			topic, rest, err := readMQTTString(body)
+			if err != nil {
+				return mqttMessage{}, err
+			}
+			if qos > 0 {
+				if len(rest) < 2 {
+					return mqttMessage{}, fmt.Errorf("%w: short PUBLISH", errMQTTProtocol)
+				}
+				if qos == 1 {
+					if err := c.write(mqttPubAck<<4, rest[:2]); err != nil {
+						return mqttMessage{}, err
+					}
+				}
+				rest = rest[2:]
+			}
+			return mqttMessage{Topic: topic, Payload: rest, Retain: header&0x01 != 0}, nil
+		case mqttSubAck:
+			for i, code := range body {
+				if i >= 2 && code == 0x80 {
+					return mqttMessage{}, errMQTTSubscribe
+				}
+			}
+		case mqttPingResp, mqttPubAck:
+		default:
+			return mqttMessage{}, fmt.Errorf("%w: unexpected packet type %d", errMQTTProtocol, header>>4)
+		}
+	}
+}
+
+// Close отправляет DISCONNECT, чтобы брокер не считал обрыв аварийным.
+func (c *mqttClient) Close() error {
+	c.write(mqttDisconnect<<4, nil)
+	return c.conn.Close()
+}
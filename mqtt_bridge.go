@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errBadSetPayload = errors.New("payload must be on or off")
+
+// MQTTConfig: пустой Broker — мост выключен. Меняется только перезапуском.
+type MQTTConfig struct {
+	Broker      string   `yaml:"broker" json:"broker"` // tcp://host:1883
+	Prefix      string   `yaml:"prefix" json:"prefix"`
+	ClientID    string   `yaml:"client_id" json:"client_id"` // по умолчанию имя хаба
+	Username    string   `yaml:"username" json:"username"`
+	Password    string   `yaml:"password" json:"-"`
+	KeepAlive   Duration `yaml:"keepalive" json:"keepalive"`
+	DialTimeout Duration `yaml:"dial_timeout" json:"dial_timeout"`
+	Reconnect   Duration `yaml:"reconnect" json:"reconnect"`
+}
+
+func defaultMQTTConfig() MQTTConfig {
+	return MQTTConfig{
+		Prefix:      "smarthub",
+		KeepAlive:   Duration(30 * time.Second),
+		DialTimeout: Duration(5 * time.Second),
+		Reconnect:   Duration(5 * time.Second),
+	}
+}
+
+// mqttBridge публикует устройства реестра retained-топиками
+// <prefix>/<name>/state и <prefix>/<name>/<канал датчика> и принимает
+// on/off из <prefix>/<name>/set. topics помнит, что уже опубликовано по
+// каждому адресу: переименованному или удалённому устройству старые
+// топики очищаются пустым retained-сообщением. Переживает переподключения.
+type mqttBridge struct {
+	hub    *Hub
+	cfg    MQTTConfig
+	client *mqttClient
+	topics map[VarUint]map[string]string
+}
+
+// RunMQTT держит мост, пока не отменён ctx; обрыв связи с брокером
+// не останавливает хаб, мост переподключается через cfg.Reconnect.
+func (h *Hub) RunMQTT(ctx context.Context, cfg MQTTConfig) {
+	b := &mqttBridge{hub: h, cfg: cfg, topics: make(map[VarUint]map[string]string)}
+	for {
+		err := b.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logf(LogWarn, "mqtt: %v, reconnecting in %s", err, time.Duration(cfg.Reconnect))
+		select {
+		case <-time.After(time.Duration(cfg.Reconnect)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *mqttBridge) session(ctx context.Context) error {
+	clientID := b.cfg.ClientID
+	if clientID == "" {
+		clientID = b.hub.Name
+	}
+	client, err := dialMQTT(ctx, b.cfg, clientID)
+	if err != nil {
+		return fmt.Errorf("connect %s: %w", b.cfg.Broker, err)
+	}
+	defer client.Close()
+	b.client = client
+	logf(LogInfo, "mqtt: connected to %s", b.cfg.Broker)
+
+	// подписка до снимка, чтобы не пропустить изменения между ними
+	sub := b.hub.events.Subscribe(256, DropOldest, func(e Event) bool {
+		switch e.Type {
+		case EventDeviceAdded, EventDeviceUpdated, EventDeviceRemoved, EventStatusChanged, EventConflict:
+			return true
+		}
+		return false
+	})
+	defer sub.Close()
+	if err := client.Subscribe(b.cfg.Prefix + "/+/set"); err != nil {
+		return err
+	}
+	if err := b.snapshot(true); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	messages := make(chan mqttMessage)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := client.ReadMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(time.Duration(b.cfg.KeepAlive) / 2)
+	defer ping.Stop()
+	dropped := sub.Dropped()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err = <-errc:
+		case msg := <-messages:
+			b.command(msg)
+		case e := <-sub.C:
+			switch e.Type {
+			case EventDeviceRemoved:
+				err = b.clear(e.Device.Address)
+			case EventConflict:
+				// конфликт помечает и второе устройство, а событие о нём одно
+				err = b.snapshot(false)
+			default:
+				err = b.sync(e.Device, false)
+			}
+			// события потерялись — проще сверить всё заново
+			if n := sub.Dropped(); err == nil && n != dropped {
+				dropped = n
+				err = b.snapshot(false)
+			}
+		case <-ping.C:
+			err = client.Ping()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// snapshot сверяет топики со всем реестром и очищает топики пропавших
+// устройств. После переподключения force: брокер мог потерять retained.
+func (b *mqttBridge) snapshot(force bool) error {
+	devices := b.hub.Devices()
+	present := make(map[VarUint]bool, len(devices))
+	for _, dev := range devices {
+		present[dev.Address] = true
+		if err := b.sync(dev, force); err != nil {
+			return err
+		}
+	}
+	for address := range b.topics {
+		if !present[address] {
+			if err := b.clear(address); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *mqttBridge) sync(dev Device, force bool) error {
+	want := deviceTopics(b.cfg.Prefix, dev)
+	have := b.topics[dev.Address]
+	for topic := range have {
+		if _, ok := want[topic]; !ok {
+			if err := b.client.Publish(topic, nil, true); err != nil {
+				return err
+			}
+		}
+	}
+	for topic, payload := range want {
+		if old, ok := have[topic]; ok && old == payload && !force {
+			continue
+		}
+		if err := b.client.Publish(topic, []byte(payload), true); err != nil {
+			return err
+		}
+	}
+	b.topics[dev.Address] = want
+	return nil
+}
+
+func (b *mqttBridge) clear(address VarUint) error {
+	for topic := range b.topics[address] {
+		if err := b.client.Publish(topic, nil, true); err != nil {
+			return err
+		}
+	}
+	delete(b.topics, address)
+	return nil
+}
+
+// command обрабатывает <prefix>/<name>/set; retained-команды брокера
+// (оставшиеся от прошлых сессий) не выполняются.
+func (b *mqttBridge) command(msg mqttMessage) {
+	name, hasPrefix := strings.CutPrefix(msg.Topic, b.cfg.Prefix+"/")
+	name, hasSuffix := strings.CutSuffix(name, "/set")
+	if !hasPrefix || !hasSuffix || msg.Retain {
+		return
+	}
+	on, err := parseOnOff(string(msg.Payload))
+	if err == nil {
+		err = b.hub.SetDeviceStatus(name, on)
+	}
+	if err != nil {
+		logf(LogWarn, "mqtt: %s: %v", msg.Topic, err)
+	}
+}
+
+func parseOnOff(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: %q", errBadSetPayload, s)
+}
+
+// deviceTopics: state — on/off для устройств с Flag, JSON для остальных,
+// у датчика ещё по топику на каждый канал. Устройства с конфликтом и
+// имена, непригодные для топика, не публикуются.
+func deviceTopics(prefix string, dev Device) map[string]string {
+	topics := make(map[string]string)
+	if dev.Conflict || dev.DevName == "" || strings.ContainsAny(dev.DevName, "/+#") {
+		return topics
+	}
+	base := prefix + "/" + dev.DevName + "/"
+	switch st := dev.Status.(type) {
+	case nil:
+	case Flag:
+		topics[base+"state"] = "off"
+		if st {
+			topics[base+"state"] = "on"
+		}
+	case EnvSensorStatusCmdBody:
+		props, _ := dev.Props.(EnvSensorProps)
+		readings := envReadings(props, st)
+		values := make(map[string]VarUint, len(readings))
+		for channel, typeSensor := range ruleSensors {
+			if value, ok := readings[typeSensor]; ok {
+				values[channel] = value
+				topics[base+channel] = strconv.FormatUint(uint64(value), 10)
+			}
+		}
+		data, _ := json.Marshal(values)
+		topics[base+"state"] = string(data)
+	default:
+		if data, err := json.Marshal(st); err == nil {
+			topics[base+"state"] = string(data)
+		}
+	}
+	return topics
+}
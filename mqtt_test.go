@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBroker — встроенный брокер MQTT 3.1.1 для тестов: QoS 0, retained,
+// фильтры с + и #.
+type testBroker struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	retained map[string][]byte
+	conns    map[*brokerConn]struct{}
+}
+
+type brokerConn struct {
+	conn    net.Conn
+	wmu     sync.Mutex
+	filters []string
+}
+
+func startTestBroker(t *testing.T) *testBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &testBroker{ln: ln, retained: make(map[string][]byte), conns: make(map[*brokerConn]struct{})}
+	t.Cleanup(func() {
+		ln.Close()
+		b.restart()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(&brokerConn{conn: conn})
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// restart рвёт соединения и теряет retained, как перезапущенный брокер.
+func (b *testBroker) restart() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		c.conn.Close()
+	}
+	b.retained = make(map[string][]byte)
+}
+
+func (b *testBroker) Retained() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snapshot := make(map[string]string, len(b.retained))
+	for topic, payload := range b.retained {
+		snapshot[topic] = string(payload)
+	}
+	return snapshot
+}
+
+func (c *brokerConn) send(header byte, body []byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.Write(brokerPacket(header, body))
+}
+
+// Брокер кадрирует пакеты своим кодом, а не клиентским: иначе ошибка в
+// оставшейся длине или строках клиента компенсировалась бы такой же.
+func brokerPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for n >= 0x80 {
+		packet = append(packet, byte(n&0x7F|0x80))
+		n >>= 7
+	}
+	packet = append(packet, byte(n))
+	return append(packet, body...)
+}
+
+func brokerReadPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, errMQTTProtocol
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func brokerString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(b[0])<<8 | int(b[1])
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
+
+func brokerAppendString(dst []byte, s string) []byte {
+	return append(append(dst, byte(len(s)>>8), byte(len(s))), s...)
+}
+
+func topicMatches(filter, topic string) bool {
+	f, n := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(n) || (level != "+" && level != n[i]) {
+			return false
+		}
+	}
+	return len(f) == len(n)
+}
+
+func (b *testBroker) serve(c *brokerConn) {
+	defer c.conn.Close()
+	r := bufio.NewReader(c.conn)
+	header, connect, err := brokerReadPacket(r)
+	if err != nil || header != 0x10 {
+		return
+	}
+	if name, rest, ok := brokerString(connect); !ok || name != "MQTT" || len(rest) < 1 || rest[0] != 4 {
+		return
+	}
+	b.mu.Lock()
+	b.conns[c] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+	}()
+	c.send(mqttConnAck<<4, []byte{0, 0})
+	for {
+		header, body, err := brokerReadPacket(r)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case mqttSubscribe:
+			if len(body) < 2 {
+				return
+			}
+			filter, _, ok := brokerString(body[2:])
+			if !ok {
+				return
+			}
+			b.mu.Lock()
+			c.filters = append(c.filters, filter)
+			var retained [][]byte
+			for topic, payload := range b.retained {
+				if topicMatches(filter, topic) {
+					retained = append(retained, append(brokerAppendString(nil, topic), payload...))
+				}
+			}
+			b.mu.Unlock()
+			c.send(mqttSubAck<<4, append(body[:2:2], 0))
+			for _, msg := range retained {
+				c.send(mqttPublish<<4|0x01, msg)
+			}
+		case mqttPublish:
+			topic, payload, ok := brokerString(body)
+			if !ok {
+				return
+			}
+			b.mu.Lock()
+			if header&0x01 != 0 {
+				if len(payload) == 0 {
+					delete(b.retained, topic)
+				} else {
+					b.retained[topic] = append([]byte(nil), payload...)
+				}
+			}
+			var targets []*brokerConn
+			for other := range b.conns {
+				for _, filter := range other.filters {
+					if topicMatches(filter, topic) {
+						targets = append(targets, other)
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+			for _, other := range targets {
+				other.send(mqttPublish<<4, body)
+			}
+		case mqttPingReq:
+			c.send(mqttPingResp<<4, nil)
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	// примеры оставшейся длины из MQTT 3.1.1, п. 2.2.3
+	for n, want := range map[int][]byte{
+		0:       {0x00},
+		127:     {0x7F},
+		128:     {0x80, 0x01},
+		16383:   {0xFF, 0x7F},
+		16384:   {0x80, 0x80, 0x01},
+		2097151: {0xFF, 0xFF, 0x7F},
+		2097152: {0x80, 0x80, 0x80, 0x01},
+	} {
+		body := make([]byte, n)
+		packet := appendMQTTPacket(nil, mqttPublish<<4, body)
+		require.Equal(t, want, packet[1:1+len(want)], n)
+		assert.Len(t, packet, 1+len(want)+n)
+		fixture := brokerPacket(mqttPublish<<4, body)
+		require.Equal(t, want, fixture[1:1+len(want)], n)
+		header, got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(fixture)))
+		require.NoError(t, err, n)
+		assert.Equal(t, mqttPublish<<4, header)
+		assert.Len(t, got, n)
+	}
+	_, _, err := readMQTTPacket(bufio.NewReader(strings.NewReader("\x30\xff\xff\xff\xff\x01")))
+	assert.ErrorIs(t, err, errMQTTProtocol)
+	_, _, err = readMQTTString(binary.BigEndian.AppendUint16(nil, 5))
+	assert.ErrorIs(t, err, errMQTTProtocol)
+
+	// PUBLISH с QoS 0 и retain: заголовок 0x31, длина, топик a/b, payload
+	assert.Equal(t, []byte{0x31, 0x07, 0x00, 0x03, 'a', '/', 'b', 'o', 'n'},
+		appendMQTTPacket(nil, mqttPublish<<4|0x01, append(appendMQTTString(nil, "a/b"), "on"...)))
+}
+
+func TestMQTTConnectRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brokerReadPacket(bufio.NewReader(conn))
+		conn.Write(brokerPacket(mqttConnAck<<4, []byte{0, 5}))
+	}()
+	cfg := defaultMQTTConfig()
+	cfg.Broker = "tcp://" + ln.Addr().String()
+	_, err = dialMQTT(context.Background(), cfg, "HUB01")
+	assert.ErrorIs(t, err, errMQTTRefused)
+	assert.ErrorContains(t, err, "not authorized")
+}
+
+func TestMQTTBridge(t *testing.T) {
+	broker := startTestBroker(t)
+	hub := newHub("HUB01", "", 1)
+	hub.mu.Lock()
+	hub.registry.Put("LAMP01", 5, LAMP, nil)
+	hub.registry.SetStatus(5, Flag(true))
+	hub.registry.Put("SENSOR01", 6, ENVSENSOR, EnvSensorProps{Sensors: 0b0101})
+	hub.registry.SetStatus(6, EnvSensorStatusCmdBody{Values: []VarUint{25, 300}})
+	hub.registry.Put("CLOCK01", 7, CLOCK, nil)
+	hub.mu.Unlock()
+
+	cfg := defaultMQTTConfig()
+	cfg.Broker = broker.url()
+	cfg.KeepAlive = Duration(time.Second)
+	cfg.Reconnect = Duration(20 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		hub.RunMQTT(ctx, cfg)
+		close(stopped)
+	}()
+
+	retainedEventually := func(want map[string]string) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(want, broker.Retained())
+		}, 2*time.Second, 5*time.Millisecond, "want %v, got %v", want, broker.Retained())
+	}
+	retainedEventually(map[string]string{
+		"smarthub/LAMP01/state":          "on",
+		"smarthub/SENSOR01/state":        `{"illumination":300,"temperature":25}`,
+		"smarthub/SENSOR01/temperature":  "25",
+		"smarthub/SENSOR01/illumination": "300",
+	})
+
+	// команда из MQTT идёт обычным путём SETSTATUS
+	client, err := dialMQTT(ctx, cfg, "test")
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Publish("smarthub/LAMP01/set", []byte("OFF"), false))
+	require.NoError(t, client.Publish("smarthub/SENSOR01/set", []byte("on"), false))
+	require.NoError(t, client.Publish("smarthub/LAMP01/set", []byte("maybe"), false))
+	assert.Eventually(t, func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return hub.requests.Queued(SETSTATUS, 5)
+	}, 2*time.Second, 5*time.Millisecond)
+	hub.mu.Lock()
+	p := hub.requests.Queue(ClassUser).At(0)
+	assert.Equal(t, 1, hub.requests.Size())
+	hub.mu.Unlock()
+	assert.Equal(t, Flag(false), p.CmdBody)
+
+	hub.mu.Lock()
+	hub.registry.SetStatus(5, Flag(false))
+	hub.registry.SetStatus(7, TimerСmdBody{Timestamp: 1000})
+	hub.registry.Put("LAMP02", 5, LAMP, nil) // переименование
+	hub.registry.Remove(6)
+	hub.mu.Unlock()
+	retainedEventually(map[string]string{
+		"smarthub/LAMP02/state":  "off",
+		"smarthub/CLOCK01/state": `{"timestamp":1000}`,
+	})
+
+	// брокер потерял retained и соединение; мост восстанавливает всё сам
+	broker.restart()
+	retainedEventually(map[string]string{
+		"smarthub/LAMP02/state":  "off",
+		"smarthub/CLOCK01/state": `{"timestamp":1000}`,
+	})
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("bridge did not stop")
+	}
+}
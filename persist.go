@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Список известных устройств сохраняется при остановке хаба и читается
+// при запуске, чтобы не ждать повторного обнаружения. Props хранятся в
+// том же бинарном виде, что и в протоколе.
+type persistedDevice struct {
+	DevName string  `json:"dev_name"`
+	Address VarUint `json:"address"`
+	DevType byte    `json:"dev_type"`
+	Props   []byte  `json:"props,omitempty"`
+}
+
+func saveDevices(path string, devices []Device) error {
+	records := make([]persistedDevice, 0, len(devices))
+	for _, dev := range devices {
+		rec := persistedDevice{DevName: dev.DevName, Address: dev.Address, DevType: dev.DevType}
+		if dev.Props != nil {
+			rec.Props = dev.Props.Append(nil)
+		}
+		records = append(records, rec)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func loadDevices(path string, registry *Registry) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []persistedDevice
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, rec := range records {
+		var props Serializer
+		if len(rec.Props) > 0 {
+			props, err = lookupDeviceType(rec.DevType).DecodeProps(rec.Props)
+			if err != nil {
+				return fmt.Errorf("%s: device %s: %w", path, rec.DevName, err)
+			}
+		}
+		registry.Put(rec.DevName, rec.Address, rec.DevType, props)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import "strings"
+
+// Периодический опрос: раз в Poll[тип] мс по часам TICK хаб шлёт GETSTATUS
+// каждому устройству этого типа. Первый опрос сдвинут на зависящую от адреса
+// долю интервала, чтобы устройства одного типа не опрашивались в один TICK.
+// Вызывается под h.mu из обработки TICK.
+
+func (c *Config) pollInterval(devType byte) VarUint {
+	name := DeviceTypeName(devType)
+	for typ, every := range c.Poll {
+		if strings.EqualFold(typ, name) {
+			return every
+		}
+	}
+	return 0
+}
+
+func pollOffset(address, every VarUint) VarUint {
+	return VarUint(uint64(address) * 2654435761 % uint64(every))
+}
+
+func (h *Hub) runPolling() {
+	if h.cfg == nil || len(h.cfg.Poll) == 0 {
+		h.pollNext = nil
+		return
+	}
+	if h.pollNext == nil {
+		h.pollNext = make(map[VarUint]VarUint)
+	}
+	for address := range h.pollNext {
+		if _, ok := h.registry.ByAddress(address); !ok {
+			delete(h.pollNext, address)
+		}
+	}
+	for _, dev := range h.registry.All() {
+		every := h.cfg.pollInterval(dev.DevType)
+		if every == 0 {
+			delete(h.pollNext, dev.Address)
+			continue
+		}
+		next, ok := h.pollNext[dev.Address]
+		if !ok {
+			h.pollNext[dev.Address] = h.now + pollOffset(dev.Address, every)
+			continue
+		}
+		if h.now < next {
+			continue
+		}
+		h.pollNext[dev.Address] = h.now + every
+		// ответа на прошлый запрос ещё ждём — повторять не нужно
+		if h.wr.Pending(STATUS, dev.Address) {
+			continue
+		}
+		h.pushGetStatus(dev)
+	}
+}
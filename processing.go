@@ -1,10 +1,13 @@
 package main
 
 func (h *Hub) processingPayload(payload Payload) {
+	if payload.Cmd != TICK {
+		h.markSeen(payload)
+	}
 	switch payload.Cmd {
 	case WHOISHERE:
 		h.Serial++
-		h.importantRequests.Push(Payload{
+		h.push(ClassDiscovery, Payload{
 			Src:     h.Address,
 			Dst:     payload.Src,
 			Serial:  h.Serial,
@@ -15,7 +18,7 @@ func (h *Hub) processingPayload(payload Payload) {
 			},
 		})
 		h.Serial++
-		h.requests.Push(Payload{
+		queued, _ := h.enqueue(ClassPolling, Payload{
 			Src:     h.Address,
 			Dst:     payload.Src,
 			Serial:  h.Serial,
@@ -29,164 +32,123 @@ func (h *Hub) processingPayload(payload Payload) {
 		if !ok {
 			return
 		}
+		h.discovered(payload.Src, payload.DevType, cmdBody)
 		h.SaveDevice(cmdBody.DevName, payload.Src, payload.DevType, cmdBody.DevProps)
-		h.wr.Add(CreateWaitRequest(4, payload.Src))
+		if queued {
+			h.wr.Add(CreateWaitRequest(4, payload.Src))
+		}
 	case IAMHERE:
-		if h.wr.size > 0 && h.wr.requests[0].isWhoIsAre() {
+		if (h.wr.size > 0 && h.wr.requests[0].isWhoIsAre()) || h.sweep != nil {
 			cmdBody, ok := payload.CmdBody.(DeviceCmdBody)
 			if !ok {
 				return
 			}
+			h.discovered(payload.Src, payload.DevType, cmdBody)
 			h.SaveDevice(cmdBody.DevName, payload.Src, payload.DevType, cmdBody.DevProps)
 			h.Serial++
-			h.requests.Push(Payload{
+			if queued, _ := h.enqueue(ClassPolling, Payload{
 				Src:     h.Address,
 				Dst:     payload.Src,
 				Serial:  h.Serial,
 				DevType: payload.DevType,
 				Cmd:     GETSTATUS,
-			})
-			h.wr.Add(CreateWaitRequest(4, payload.Src))
+			}); queued {
+				h.wr.Add(CreateWaitRequest(4, payload.Src))
+			}
 		}
 	case STATUS:
-		switch payload.DevType {
-		case ENVSENSOR:
-			device, ok := h.DevicesWithAddress[payload.Src]
-			if ok {
-				cmdBody, ok := payload.CmdBody.(EnvSensorStatusCmdBody)
-				if !ok {
-					return
-				}
-				i := byte(0)
-				typeSensor := byte(0)
-				props, ok := device.Body.(EnvSensorProps)
-				if !ok {
-					return
-				}
-				for b := byte(1); b <= 8; b *= 2 {
-					if h.processingStatusSensor(props, b, cmdBody.Values[i], typeSensor) {
-						i++
-					}
-					typeSensor++
-				}
-			}
-		case SWITCH:
-			device, ok := h.DevicesWithAddress[payload.Src]
-			if ok {
-				cmdBody, ok := payload.CmdBody.(Flag)
-				if !ok {
-					return
-				}
-				devices, ok := device.Body.(SerStrings)
-				if !ok {
-					return
-				}
-				h.processingStatusSwitch(devices, cmdBody)
+		if device, ok := h.registry.ByAddress(payload.Src); ok {
+			lookupDeviceType(payload.DevType).HandleStatus(h, device, payload.CmdBody)
+			h.applyRules(device, payload.CmdBody)
+		}
+		if wr, ok := h.takeWaitRequest(payload.Cmd, payload.Src); ok {
+			// ответ до следующего TICK считаем мгновенным
+			var latency VarUint
+			if wr.wasFirstTick {
+				latency = h.now - wr.firstTick
 			}
-		case LAMP, SOCKET:
-			h.SaveStatus(payload.Src, payload.CmdBody)
+			h.health.Response(payload.Src, latency)
 		}
-		h.DeleteFromWR(payload.Cmd, payload.Src)
 	case TICK:
 		if t, ok := payload.CmdBody.(TimerСmdBody); ok {
+			h.now = t.Timestamp
 			addresses := h.wr.CheckWaitRequests(t.Timestamp)
-			h.DeleteDevices(addresses)
+			for _, address := range addresses {
+				h.missedResponse(address)
+			}
+			h.runSchedules()
+			h.runPolling()
+			h.runDiscovery()
 		} else {
 			return
 		}
 	}
 }
 
-func (h *Hub) processingStatusSensor(props EnvSensorProps, b byte, value VarUint, xType byte) bool {
-	if props.Sensors&b == 0 {
-		return false
-	}
+func (h *Hub) SaveStatus(address VarUint, status Serializer) {
+	h.registry.SetStatus(address, status)
+}
 
-	for _, trigger := range props.Triggers {
-		typeSensor := (trigger.Op & 12) / 4
-		if typeSensor != xType {
-			continue
-		}
-		border := trigger.Value
-		comp := (trigger.Op & 2) / 2
-		dev, ok := h.DevicesWithName[trigger.Name]
-		if !ok {
-			continue
-		}
+func (h *Hub) DeleteFromWR(cmd byte, address VarUint) {
+	h.takeWaitRequest(cmd, address)
+}
 
-		setStatus := Flag(false)
-		if trigger.Op&1 == 1 {
-			setStatus = true
-		}
-		if (comp == 1 && value > border) || (comp == 0 && value < border) {
-			h.Serial++
-			h.requests.Push(Payload{
-				Src:     h.Address,
-				Dst:     dev.Address,
-				Serial:  h.Serial,
-				DevType: dev.DevType,
-				Cmd:     SETSTATUS,
-				CmdBody: setStatus,
-			})
-			h.wr.Add(CreateWaitRequest(STATUS, dev.Address))
+func (h *Hub) takeWaitRequest(cmd byte, address VarUint) (waitRequest, bool) {
+	for i := 0; i < len(h.wr.requests); i++ {
+		if cmd == h.wr.requests[i].Cmd && address == h.wr.requests[i].Address {
+			wr := h.wr.requests[i]
+			h.wr.requests = append(h.wr.requests[:i], h.wr.requests[i+1:]...)
+			h.wr.size--
+			if i < h.wr.cntWithNotNull {
+				h.wr.cntWithNotNull--
+			}
+			return wr, true
 		}
 	}
-	return true
+	return waitRequest{}, false
 }
 
-func (h *Hub) processingStatusSwitch(props SerStrings, setStatus Flag) {
-	for _, devName := range props {
-		device, ok := h.DevicesWithName[devName]
-		if !ok {
-			continue
-		}
-		h.Serial++
-		h.requests.Push(Payload{
-			Src:     h.Address,
-			Dst:     device.Address,
-			Serial:  h.Serial,
-			DevType: device.DevType,
-			Cmd:     SETSTATUS,
-			CmdBody: setStatus,
-		})
-		h.wr.Add(CreateWaitRequest(STATUS, device.Address))
-
+func (h *Hub) markSeen(payload Payload) {
+	dev, ok := h.registry.ByAddress(payload.Src)
+	if !ok {
+		return
+	}
+	if old, state := h.health.Seen(payload.Src, dev.DevName, h.now); old != state {
+		h.publishHealth(dev)
 	}
 }
 
-func (h *Hub) SaveStatus(address VarUint, status Serializer) {
-	device, ok := h.DevicesWithAddress[address]
+// Устройство не ответило вовремя. Пока порог удаления не достигнут,
+// хаб переспрашивает его статус.
+func (h *Hub) missedResponse(address VarUint) {
+	dev, ok := h.registry.ByAddress(address)
 	if !ok {
 		return
 	}
-	device.Body = status
-	h.DevicesWithAddress[address] = device
-	h.DevicesWithName[device.DevName] = device
+	h.publish(Event{Type: EventTimeout, Device: dev})
+	old, state := h.health.Missed(address)
+	if old != state {
+		h.publishHealth(dev)
+	}
+	if state == HealthRemoved {
+		h.DeleteDevices([]VarUint{address})
+		return
+	}
+	h.pushGetStatus(dev)
 }
 
-func (h *Hub) DeleteFromWR(cmd byte, address VarUint) {
-	for i := 0; i < len(h.wr.requests); i++ {
-		if cmd == h.wr.requests[i].Cmd && address != h.wr.requests[i].Address {
-			h.wr.requests = append(h.wr.requests[:i], h.wr.requests[i+1:]...)
-			h.wr.size--
-			break
-		}
+func (h *Hub) publishHealth(dev Device) {
+	if dh, ok := h.health.Get(dev.Address); ok {
+		h.publish(Event{Type: EventHealthChanged, Device: dev, Health: &dh})
 	}
 }
 
-func (h *Hub) SaveDevice(name string, address VarUint, devType byte, body Serializer) {
-	dev := newDevice(name, address, devType, body)
-	if val, ok := h.DevicesWithAddress[address]; ok {
-		delete(h.DevicesWithName, val.DevName)
-	}
-	h.DevicesWithName[name] = dev
-	h.DevicesWithAddress[address] = dev
+func (h *Hub) SaveDevice(name string, address VarUint, devType byte, props Serializer) {
+	h.registry.Put(name, address, devType, props)
 }
 
 func (h *Hub) DeleteDevices(addresses []VarUint) {
 	for _, val := range addresses {
-		name := h.DevicesWithAddress[val].DevName
-		delete(h.DevicesWithAddress, val)
-		delete(h.DevicesWithName, name)
+		h.registry.Remove(val)
 	}
 }
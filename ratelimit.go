@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errRateLimited = errors.New("rate limited")
+
+// Ограничение исходящих SETSTATUS и GETSTATUS маркерным ведром по часам
+// TICK: раз в Every мс добавляется маркер, в ведре не больше Burst.
+// Every == 0 — без ограничения.
+type RateLimit struct {
+	Every VarUint `yaml:"every" json:"every"`
+	Burst int     `yaml:"burst" json:"burst"`
+}
+
+type RateLimitConfig struct {
+	PerDevice RateLimit `yaml:"per_device" json:"per_device"`
+	Global    RateLimit `yaml:"global" json:"global"`
+}
+
+type tokenBucket struct {
+	tokens int
+	last   VarUint
+}
+
+func newTokenBucket(l RateLimit, now VarUint) *tokenBucket {
+	return &tokenBucket{tokens: l.Burst, last: now}
+}
+
+func (b *tokenBucket) refill(l RateLimit, now VarUint) {
+	if now < b.last {
+		b.last = now
+	}
+	n := (now - b.last) / l.Every
+	if n == 0 {
+		return
+	}
+	if VarUint(b.tokens)+n >= VarUint(l.Burst) {
+		b.tokens, b.last = l.Burst, now
+		return
+	}
+	b.tokens += int(n)
+	b.last += n * l.Every
+}
+
+// RateLimiter работает под h.mu, своей блокировки у него нет.
+type RateLimiter struct {
+	cfg       RateLimitConfig
+	global    *tokenBucket
+	perDevice map[VarUint]*tokenBucket
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{perDevice: make(map[VarUint]*tokenBucket)}
+}
+
+func (r *RateLimiter) SetConfig(cfg RateLimitConfig) {
+	if cfg != r.cfg {
+		r.cfg = cfg
+		r.global = nil
+		r.perDevice = make(map[VarUint]*tokenBucket)
+	}
+}
+
+// Allow забирает маркер для пакета dst. Если маркера нет, возвращает
+// false и какое ограничение сработало: "device" или "global".
+func (r *RateLimiter) Allow(dst, now VarUint) (bool, string) {
+	var device, global *tokenBucket
+	if l := r.cfg.PerDevice; l.Every > 0 {
+		device = r.perDevice[dst]
+		if device == nil {
+			device = newTokenBucket(l, now)
+			r.perDevice[dst] = device
+		}
+		device.refill(l, now)
+		if device.tokens == 0 {
+			return false, "device"
+		}
+	}
+	if l := r.cfg.Global; l.Every > 0 {
+		if r.global == nil {
+			r.global = newTokenBucket(l, now)
+		}
+		global = r.global
+		global.refill(l, now)
+		if global.tokens == 0 {
+			return false, "global"
+		}
+	}
+	if device != nil {
+		device.tokens--
+	}
+	if global != nil {
+		global.tokens--
+	}
+	return true, ""
+}
+
+func (r *RateLimiter) Forget(dst VarUint) {
+	delete(r.perDevice, dst)
+}
+
+// enqueue ставит GETSTATUS или SETSTATUS в очередь с учётом ограничений.
+// Пакет, который сольётся с уже стоящим в очереди, маркер не тратит.
+// Ошибка — переполнение очереди с политикой reject и, только для команд
+// пользователя, errRateLimited: автоматика отбрасывается молча.
+func (h *Hub) enqueue(class RequestClass, p Payload) (bool, error) {
+	if !h.requests.Queued(p.Cmd, p.Dst) {
+		if ok, scope := h.limiter.Allow(p.Dst, h.now); !ok {
+			logf(LogWarn, "rate limit (%s): dropping %s to %#x", scope, CmdName(p.Cmd), p.Dst)
+			h.metrics.Inc("smarthub_rate_limited_total", "scope", scope, "cmd", CmdName(p.Cmd))
+			if class == ClassUser {
+				return false, fmt.Errorf("%s to %#x: %w (%s)", CmdName(p.Cmd), p.Dst, errRateLimited, scope)
+			}
+			return false, nil
+		}
+	}
+	return h.push(class, p)
+}
+
+// push ставит пакет в очередь без ограничений; отказ очереди пишется в лог.
+func (h *Hub) push(class RequestClass, p Payload) (bool, error) {
+	queued, err := h.requests.Push(class, p)
+	if err != nil {
+		logf(LogWarn, "%s queue: dropping %s to %#x: %v", class, CmdName(p.Cmd), p.Dst, err)
+		return false, fmt.Errorf("%s queue: %w", class, err)
+	}
+	if queued {
+		h.metrics.Inc("smarthub_requests_queued_total", "class", class.String(), "cmd", CmdName(p.Cmd))
+	}
+	return queued, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := NewRateLimiter()
+	r.SetConfig(RateLimitConfig{
+		PerDevice: RateLimit{Every: 1000, Burst: 2},
+		Global:    RateLimit{Every: 100, Burst: 3},
+	})
+	allow := func(dst, now VarUint) string {
+		if ok, scope := r.Allow(dst, now); !ok {
+			return scope
+		}
+		return "ok"
+	}
+	assert.Equal(t, "ok", allow(5, 0))
+	assert.Equal(t, "ok", allow(5, 0))
+	assert.Equal(t, "device", allow(5, 0))
+	assert.Equal(t, "ok", allow(6, 0))
+	assert.Equal(t, "global", allow(7, 0))
+	assert.Equal(t, "ok", allow(7, 100))
+	assert.Equal(t, "device", allow(5, 999))
+	assert.Equal(t, "ok", allow(5, 1000))
+	// ведро наполняется не больше чем до Burst
+	assert.Equal(t, "ok", allow(6, 10000))
+	assert.Equal(t, "ok", allow(6, 10000))
+	assert.Equal(t, "device", allow(6, 10000))
+}
+
+func TestHubRateLimit(t *testing.T) {
+	hub := newTestHub()
+	hub.wr = CreateWaitRequests()
+	hub.limiter.SetConfig(RateLimitConfig{PerDevice: RateLimit{Every: 1000, Burst: 1}})
+	hub.SaveDevice("SOCKET01", 12, SOCKET, nil)
+	hub.SaveDevice("SENSOR01", 14, ENVSENSOR, EnvSensorProps{Sensors: 1, Triggers: []Trigger{{Op: 0b0011, Value: 30, Name: "SOCKET01"}}})
+
+	sent := 0
+	for ts := VarUint(100); ts <= 2000; ts += 100 {
+		hub.processingPayload(tick(ts))
+		hub.processingPayload(Payload{Src: 14, Dst: 1, DevType: ENVSENSOR, Cmd: STATUS, CmdBody: EnvSensorStatusCmdBody{Values: []VarUint{40}}})
+		for _, p := range hub.requests.GetAllAndClear() {
+			if p.Dst == 12 && p.Cmd == SETSTATUS {
+				sent++
+				hub.processingPayload(Payload{Src: 12, Dst: 1, DevType: SOCKET, Cmd: STATUS, CmdBody: Flag(false)})
+			}
+		}
+	}
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, float64(18), hub.metrics.Value("smarthub_rate_limited_total", "scope", "device", "cmd", "SETSTATUS"))
+
+	var out strings.Builder
+	hub.collectMetrics()
+	hub.metrics.WriteTo(&out)
+	assert.Contains(t, out.String(), "# TYPE smarthub_rate_limited_total counter\n")
+	assert.Contains(t, out.String(), `smarthub_rate_limited_total{scope="device",cmd="SETSTATUS"} 18`)
+	assert.Contains(t, out.String(), `smarthub_queue_depth{class="polling"} 0`)
+}
+
+func TestUserCommandRateLimited(t *testing.T) {
+	hub := newTestHub()
+	hub.limiter.SetConfig(RateLimitConfig{PerDevice: RateLimit{Every: 1000, Burst: 1}})
+	hub.SaveDevice("SOCKET01", 12, SOCKET, nil)
+
+	assert.NoError(t, hub.SetDeviceStatus("SOCKET01", true))
+	hub.requests.GetAllAndClear()
+	assert.ErrorIs(t, hub.SetDeviceStatus("SOCKET01", false), errRateLimited)
+	assert.Zero(t, hub.requests.Size())
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type ChangeKind byte
+
+const (
+	DeviceAdded ChangeKind = iota + 1
+	DeviceUpdated
+	DeviceRemoved
+	StatusChanged
+	DeviceConflict
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case DeviceAdded:
+		return "added"
+	case DeviceUpdated:
+		return "updated"
+	case DeviceRemoved:
+		return "removed"
+	case StatusChanged:
+		return "status"
+	case DeviceConflict:
+		return "conflict"
+	default:
+		return "unknown"
+	}
+}
+
+type RegistryChange struct {
+	Kind     ChangeKind
+	Device   Device
+	Old      Device
+	Conflict *Conflict
+}
+
+type ConflictPolicy string
+
+const (
+	FirstWins  ConflictPolicy = "first_wins"
+	LastWins   ConflictPolicy = "last_wins"
+	Quarantine ConflictPolicy = "quarantine"
+)
+
+var errUnknownConflictPolicy = errors.New("unknown conflict policy")
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case FirstWins, LastWins, Quarantine:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w: %q", errUnknownConflictPolicy, s)
+	}
+}
+
+type ConflictKind string
+
+const (
+	ConflictName    ConflictKind = "name"
+	ConflictAddress ConflictKind = "address"
+)
+
+type Conflict struct {
+	Kind    ConflictKind `json:"kind"`
+	Name    string       `json:"name,omitempty"`
+	Address VarUint      `json:"address,omitempty"`
+	Devices []Device     `json:"devices"`
+}
+
+func (c Conflict) key() string {
+	if c.Kind == ConflictName {
+		return "name:" + c.Name
+	}
+	return fmt.Sprintf("address:%d", c.Address)
+}
+
+// Registry — единственный владелец индексов устройств по адресу и по имени.
+// Индекс по имени хранит только адрес и указывает лишь на устройства без
+// конфликта. Когда два устройства делят имя или адрес, обе записи
+// остаются в реестре с пометкой Conflict, а кто из них доступен по имени,
+// решает ConflictPolicy. Конфликт по адресу — это другой тип устройства на
+// занятом адресе; тот же тип под новым именем — переименование.
+type Registry struct {
+	mu        sync.RWMutex
+	byAddress map[VarUint]Device
+	byName    map[string]VarUint
+	conflicts map[string]*Conflict
+	policy    ConflictPolicy
+	watchers  []func(RegistryChange)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byAddress: make(map[VarUint]Device),
+		byName:    make(map[string]VarUint),
+		conflicts: make(map[string]*Conflict),
+		policy:    LastWins,
+	}
+}
+
+func (r *Registry) SetPolicy(policy ConflictPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = policy
+}
+
+// Watch вызывается синхронно, после снятия блокировки реестра.
+func (r *Registry) Watch(fn func(RegistryChange)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watchers = append(r.watchers, fn)
+}
+
+func (r *Registry) notify(changes []RegistryChange) {
+	r.mu.RLock()
+	watchers := r.watchers
+	r.mu.RUnlock()
+	for _, change := range changes {
+		for _, fn := range watchers {
+			fn(change)
+		}
+	}
+}
+
+func (r *Registry) unindex(dev Device) {
+	if address, ok := r.byName[dev.DevName]; ok && address == dev.Address {
+		delete(r.byName, dev.DevName)
+	}
+}
+
+func (r *Registry) addConflict(c Conflict, devices ...Device) RegistryChange {
+	stored, ok := r.conflicts[c.key()]
+	if !ok {
+		stored = &c
+		r.conflicts[c.key()] = stored
+	}
+	for _, dev := range devices {
+		found := false
+		for i := range stored.Devices {
+			if stored.Devices[i].Address == dev.Address {
+				stored.Devices[i], found = dev, true
+			}
+		}
+		if !found {
+			stored.Devices = append(stored.Devices, dev)
+		}
+	}
+	snapshot := *stored
+	snapshot.Devices = append([]Device(nil), stored.Devices...)
+	return RegistryChange{Kind: DeviceConflict, Device: devices[len(devices)-1], Conflict: &snapshot}
+}
+
+// Устройство уходит из конфликта по имени (удалено или переименовано).
+// Если претендент остался один, он снова доступен по имени.
+func (r *Registry) leaveNameConflict(dev Device) []RegistryChange {
+	key := Conflict{Kind: ConflictName, Name: dev.DevName}.key()
+	c, ok := r.conflicts[key]
+	if !ok {
+		return nil
+	}
+	devices := c.Devices[:0]
+	for _, val := range c.Devices {
+		if val.Address != dev.Address {
+			devices = append(devices, val)
+		}
+	}
+	c.Devices = devices
+	if len(devices) > 1 {
+		return nil
+	}
+	delete(r.conflicts, key)
+	if len(devices) == 0 {
+		return nil
+	}
+	rest, ok := r.byAddress[devices[0].Address]
+	if !ok || rest.DevName != c.Name || !rest.Conflict {
+		return nil
+	}
+	old := rest
+	rest.Conflict = false
+	r.byAddress[rest.Address] = rest
+	r.byName[rest.DevName] = rest.Address
+	return []RegistryChange{{Kind: DeviceUpdated, Device: rest, Old: old}}
+}
+
+func (r *Registry) Put(name string, address VarUint, devType byte, props Serializer) {
+	r.mu.Lock()
+	changes := make([]RegistryChange, 0, 2)
+	dev := newDevice(name, address, devType, props)
+	old, existed := r.byAddress[address]
+	addressKey := Conflict{Kind: ConflictAddress, Address: address}.key()
+	switch {
+	case existed && old.DevType == devType:
+		// повторный анонс или переименование: другая сторона конфликта
+		// по адресу с тех пор не появлялась
+		dev.Status = old.Status
+		delete(r.conflicts, addressKey)
+	case existed:
+		changes = append(changes, r.addConflict(Conflict{Kind: ConflictAddress, Address: address}, old, dev))
+		switch r.policy {
+		case FirstWins:
+			r.mu.Unlock()
+			r.notify(changes)
+			return
+		case LastWins:
+			// прежнего устройства в реестре больше нет
+			delete(r.conflicts, addressKey)
+		case Quarantine:
+			dev.Conflict = true
+		}
+	}
+	if existed {
+		r.unindex(old)
+		if old.DevName != name {
+			changes = append(changes, r.leaveNameConflict(old)...)
+		}
+	}
+
+	holder, held := r.byName[name]
+	_, quarantined := r.conflicts[Conflict{Kind: ConflictName, Name: name}.key()]
+	quarantined = quarantined && r.policy == Quarantine
+	if !dev.Conflict && ((held && holder != address) || quarantined) {
+		var other Device
+		if held {
+			other = r.byAddress[holder]
+		}
+		switch r.policy {
+		case FirstWins:
+			dev.Conflict = true
+		case LastWins:
+			other.Conflict = true
+			r.byAddress[holder] = other
+		case Quarantine:
+			dev.Conflict = true
+			if held {
+				other.Conflict = true
+				r.byAddress[holder] = other
+				delete(r.byName, name)
+			}
+		}
+		contenders := []Device{dev}
+		if held {
+			contenders = []Device{other, dev}
+		}
+		changes = append(changes, r.addConflict(Conflict{Kind: ConflictName, Name: name}, contenders...))
+	}
+
+	if existed {
+		changes = append(changes, RegistryChange{Kind: DeviceUpdated, Device: dev, Old: old})
+	} else {
+		changes = append(changes, RegistryChange{Kind: DeviceAdded, Device: dev})
+	}
+	r.byAddress[address] = dev
+	if !dev.Conflict {
+		r.byName[name] = address
+	}
+	r.mu.Unlock()
+	r.notify(changes)
+}
+
+func (r *Registry) Conflicts() []Conflict {
+	r.mu.RLock()
+	conflicts := make([]Conflict, 0, len(r.conflicts))
+	for _, c := range r.conflicts {
+		snapshot := *c
+		snapshot.Devices = append([]Device(nil), c.Devices...)
+		conflicts = append(conflicts, snapshot)
+	}
+	r.mu.RUnlock()
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].key() < conflicts[j].key() })
+	return conflicts
+}
+
+func (r *Registry) SetStatus(address VarUint, status Serializer) bool {
+	r.mu.Lock()
+	dev, ok := r.byAddress[address]
+	if !ok {
+		r.mu.Unlock()
+		return false
+	}
+	old := dev
+	dev.Status = status
+	r.byAddress[address] = dev
+	r.mu.Unlock()
+	r.notify([]RegistryChange{{Kind: StatusChanged, Device: dev, Old: old}})
+	return true
+}
+
+func (r *Registry) Remove(address VarUint) (Device, bool) {
+	r.mu.Lock()
+	dev, ok := r.byAddress[address]
+	if !ok {
+		r.mu.Unlock()
+		return Device{}, false
+	}
+	delete(r.byAddress, address)
+	r.unindex(dev)
+	delete(r.conflicts, Conflict{Kind: ConflictAddress, Address: address}.key())
+	changes := append([]RegistryChange{{Kind: DeviceRemoved, Device: dev}}, r.leaveNameConflict(dev)...)
+	r.mu.Unlock()
+	r.notify(changes)
+	return dev, true
+}
+
+func (r *Registry) ByAddress(address VarUint) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	dev, ok := r.byAddress[address]
+	return dev, ok
+}
+
+func (r *Registry) ByName(name string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	address, ok := r.byName[name]
+	if !ok {
+		return Device{}, false
+	}
+	return r.byAddress[address], true
+}
+
+func (r *Registry) All() []Device {
+	r.mu.RLock()
+	devices := make([]Device, 0, len(r.byAddress))
+	for _, dev := range r.byAddress {
+		devices = append(devices, dev)
+	}
+	r.mu.RUnlock()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Address < devices[j].Address })
+	return devices
+}
+
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byAddress)
+}
+
+func describeConflict(c *Conflict) string {
+	parts := make([]string, 0, len(c.Devices))
+	for _, dev := range c.Devices {
+		parts = append(parts, fmt.Sprintf("%s@%#x", dev.DevName, dev.Address))
+	}
+	return strings.Join(parts, ", ")
+}
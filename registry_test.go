@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryKeepsIndexesConsistent(t *testing.T) {
+	r := NewRegistry()
+	var changes []ChangeKind
+	r.Watch(func(c RegistryChange) { changes = append(changes, c.Kind) })
+
+	r.Put("LAMP01", 10, LAMP, nil)
+	assert.True(t, r.SetStatus(10, Flag(true)))
+	r.Put("LAMP01", 10, LAMP, nil)
+	dev, _ := r.ByName("LAMP01")
+	assert.Equal(t, Flag(true), dev.Status, "re-announce keeps status")
+
+	// переименование освобождает старое имя
+	r.Put("LAMP02", 10, LAMP, nil)
+	_, ok := r.ByName("LAMP01")
+	assert.False(t, ok)
+
+	// второй адрес с тем же именем: по умолчанию побеждает последний,
+	// первый остаётся в реестре с пометкой конфликта
+	r.Put("LAMP02", 11, LAMP, nil)
+	dev, _ = r.ByAddress(10)
+	assert.True(t, dev.Conflict)
+	dev, _ = r.ByName("LAMP02")
+	assert.Equal(t, VarUint(11), dev.Address)
+	assert.Equal(t, 2, r.Len())
+
+	r.Put("SW01", 12, SWITCH, SerStrings{"LAMP02"})
+	r.SetStatus(12, Flag(true))
+	dev, _ = r.ByAddress(12)
+	assert.Equal(t, SerStrings{"LAMP02"}, dev.Props)
+	assert.Equal(t, Flag(true), dev.Status)
+
+	_, ok = r.Remove(12)
+	assert.True(t, ok)
+	assert.False(t, r.SetStatus(12, Flag(false)))
+	assert.Equal(t, []ChangeKind{DeviceAdded, StatusChanged, DeviceUpdated, DeviceUpdated,
+		DeviceConflict, DeviceAdded, DeviceAdded, StatusChanged, DeviceRemoved}, changes)
+}
+
+func TestRegistryConcurrentReaders(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r.All()
+				r.ByName("LAMP01")
+			}
+		}()
+	}
+	for j := VarUint(0); j < 1000; j++ {
+		r.Put("LAMP01", j%7, LAMP, nil)
+		r.SetStatus(j%7, Flag(j%2 == 0))
+	}
+	wg.Wait()
+	assert.Equal(t, 7, r.Len())
+	dev, _ := r.ByName("LAMP01")
+	assert.Equal(t, VarUint(999%7), dev.Address)
+}
+
+func TestRegistryConflictPolicies(t *testing.T) {
+	for _, c := range []struct {
+		policy ConflictPolicy
+		holder VarUint
+		held   bool
+	}{
+		{FirstWins, 10, true},
+		{LastWins, 11, true},
+		{Quarantine, 0, false},
+	} {
+		r := NewRegistry()
+		r.SetPolicy(c.policy)
+		r.Put("LAMP01", 10, LAMP, nil)
+		r.Put("LAMP01", 11, LAMP, nil)
+		dev, ok := r.ByName("LAMP01")
+		assert.Equal(t, c.held, ok, c.policy)
+		assert.Equal(t, c.holder, dev.Address, c.policy)
+		assert.Equal(t, 2, r.Len(), c.policy)
+		conflicts := r.Conflicts()
+		assert.Len(t, conflicts, 1, c.policy)
+		assert.Equal(t, ConflictName, conflicts[0].Kind)
+		assert.Len(t, conflicts[0].Devices, 2)
+
+		// третий претендент в карантине тоже не получает имя
+		r.Put("LAMP01", 12, LAMP, nil)
+		if c.policy == Quarantine {
+			_, ok = r.ByName("LAMP01")
+			assert.False(t, ok)
+			assert.Len(t, r.Conflicts()[0].Devices, 3)
+			r.Remove(12)
+		}
+
+		// когда претендент остаётся один, конфликт снимается
+		r.Remove(11)
+		r.Remove(12)
+		dev, ok = r.ByName("LAMP01")
+		assert.True(t, ok, c.policy)
+		assert.Equal(t, VarUint(10), dev.Address)
+		assert.False(t, dev.Conflict)
+		assert.Empty(t, r.Conflicts(), c.policy)
+	}
+
+	r := NewRegistry()
+	r.SetPolicy(FirstWins)
+	r.Put("LAMP01", 10, LAMP, nil)
+	r.Put("SOCKET01", 10, SOCKET, nil)
+	dev, _ := r.ByAddress(10)
+	assert.Equal(t, "LAMP01", dev.DevName)
+	assert.Equal(t, ConflictAddress, r.Conflicts()[0].Kind)
+
+	r.SetPolicy(Quarantine)
+	r.Put("SOCKET01", 10, SOCKET, nil)
+	dev, _ = r.ByAddress(10)
+	assert.True(t, dev.Conflict)
+	_, ok := r.ByName("LAMP01")
+	assert.False(t, ok)
+	_, ok = r.ByName("SOCKET01")
+	assert.False(t, ok)
+
+	// повторный анонс прежнего типа: другая сторона ушла
+	r.Put("SOCKET01", 10, SOCKET, nil)
+	dev, _ = r.ByName("SOCKET01")
+	assert.False(t, dev.Conflict)
+	assert.Empty(t, r.Conflicts())
+
+	// при last_wins прежнего устройства в реестре уже нет
+	r.SetPolicy(LastWins)
+	r.Put("LAMP01", 10, LAMP, nil)
+	dev, _ = r.ByName("LAMP01")
+	assert.Equal(t, LAMP, dev.DevType)
+	assert.Empty(t, r.Conflicts())
+
+	_, err := ParseConflictPolicy("random")
+	assert.ErrorIs(t, err, errUnknownConflictPolicy)
+}
+
+func TestRegistryRenameIsNotConflict(t *testing.T) {
+	for _, policy := range []ConflictPolicy{FirstWins, LastWins, Quarantine} {
+		r := NewRegistry()
+		r.SetPolicy(policy)
+		var changes []ChangeKind
+		r.Watch(func(c RegistryChange) { changes = append(changes, c.Kind) })
+		r.Put("LAMP01", 10, LAMP, nil)
+		r.SetStatus(10, Flag(true))
+		r.Put("LAMP02", 10, LAMP, nil)
+
+		dev, ok := r.ByName("LAMP02")
+		assert.True(t, ok, policy)
+		assert.False(t, dev.Conflict, policy)
+		assert.Equal(t, Flag(true), dev.Status, policy)
+		_, ok = r.ByName("LAMP01")
+		assert.False(t, ok, policy)
+		assert.Empty(t, r.Conflicts(), policy)
+		assert.Equal(t, []ChangeKind{DeviceAdded, StatusChanged, DeviceUpdated}, changes, policy)
+	}
+
+	// переименование освобождает место в конфликте по имени
+	r := NewRegistry()
+	r.SetPolicy(Quarantine)
+	r.Put("LAMP01", 10, LAMP, nil)
+	r.Put("LAMP01", 11, LAMP, nil)
+	r.Put("LAMP02", 11, LAMP, nil)
+	for _, name := range []string{"LAMP01", "LAMP02"} {
+		dev, ok := r.ByName(name)
+		assert.True(t, ok, name)
+		assert.False(t, dev.Conflict, name)
+	}
+	assert.Empty(t, r.Conflicts())
+}
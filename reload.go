@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const configPollInterval = 2 * time.Second
+
+var errReloadUnavailable = errors.New("config reload is not configured")
+
+// Reload перечитывает конфиг тем же способом, что и при запуске, и
+// подменяет правила, группы, расписания, таймауты и политики. Имя, адрес,
+// URL хаба и мост MQTT меняются только перезапуском. При ошибке остаётся
+// старый конфиг.
+func (h *Hub) Reload() error {
+	if h.loadConfig == nil {
+		return errReloadUnavailable
+	}
+	cfg, err := h.loadConfig()
+	if err != nil {
+		logf(LogError, "config reload failed, keeping previous config: %v", err)
+		return err
+	}
+
+	h.mu.Lock()
+	old := h.cfg
+	if old != nil {
+		if cfg.Name != old.Name || cfg.address != old.address || cfg.URL != old.URL {
+			logf(LogWarn, "config reload: name, address and url need a restart, keeping %s@%#x %s", old.Name, old.address, old.URL)
+		}
+		cfg.Name, cfg.Address, cfg.address, cfg.URL = old.Name, old.Address, old.address, old.URL
+		if cfg.MQTT != old.MQTT {
+			logf(LogWarn, "config reload: mqtt needs a restart, keeping previous settings")
+			cfg.MQTT = old.MQTT
+		}
+	}
+	if old == nil || cfg.Log != old.Log {
+		if err := setupLogging(cfg.Log); err != nil {
+			h.mu.Unlock()
+			logf(LogError, "config reload failed, keeping previous config: %v", err)
+			return err
+		}
+	}
+	h.cfg = cfg
+	h.wr.timeout = cfg.ResponseTimeout
+	h.registry.SetPolicy(cfg.ConflictPolicy)
+	h.requests.SetConfig(cfg.Scheduler)
+	h.limiter.SetConfig(cfg.RateLimit)
+	h.health.SetConfig(cfg.Health)
+	h.mu.Unlock()
+
+	logf(LogInfo, "config reloaded: %d rules, %d groups, %d schedules", len(cfg.Rules), len(cfg.Groups), len(cfg.Schedules))
+	return nil
+}
+
+func (h *Hub) configPath() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cfg == nil {
+		return ""
+	}
+	return h.cfg.path
+}
+
+// WatchConfig перезагружает конфиг при изменении файла и по SIGHUP,
+// пока не отменён ctx. Не блокирует.
+func (h *Hub) WatchConfig(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	lastMod := configModTime(h.configPath())
+	go h.watchConfig(ctx, interval, hup, lastMod)
+}
+
+func (h *Hub) watchConfig(ctx context.Context, interval time.Duration, hup chan os.Signal, lastMod time.Time) {
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var pendingMod time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logf(LogInfo, "SIGHUP: reloading config")
+			h.Reload()
+			lastMod = configModTime(h.configPath())
+		case <-ticker.C:
+			mod := configModTime(h.configPath())
+			if mod.IsZero() || mod.Equal(lastMod) {
+				continue
+			}
+			// файл мог быть записан не до конца: ждём, пока mtime не
+			// перестанет меняться между двумя опросами
+			if !mod.Equal(pendingMod) {
+				pendingMod = mod
+				continue
+			}
+			lastMod = mod
+			logf(LogInfo, "config file changed: reloading")
+			h.Reload()
+		}
+	}
+}
+
+func configModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (h *Hub) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.Reload(); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": fmt.Sprintf("reloaded %s", h.configPath())})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const ringMinBuffer = 4
+
+var (
+	errQueueFull             = errors.New("queue is full")
+	errUnknownOverflowPolicy = errors.New("unknown overflow policy")
+)
+
+var overflowPolicyNames = map[OverflowPolicy]string{
+	DropNewest: "drop_newest",
+	DropOldest: "drop_oldest",
+	Block:      "block",
+	Reject:     "reject",
+}
+
+func (p OverflowPolicy) String() string {
+	if name, ok := overflowPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("overflow(%d)", byte(p))
+}
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for p, name := range overflowPolicyNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", errUnknownOverflowPolicy, s)
+}
+
+// Ring — очередь FIFO на кольцевом буфере. Буфер растёт удвоением и
+// сжимается, когда опустеет на три четверти, так что память отпускается.
+// capacity 0 — без ограничения. Ring не потокобезопасен.
+type Ring[T any] struct {
+	buf       []T
+	head      int
+	size      int
+	capacity  int
+	policy    OverflowPolicy
+	dropped   int
+	highWater int
+	// OnDrop вызывается для элемента, вытесненного политикой DropOldest.
+	OnDrop func(T)
+}
+
+func NewRing[T any](capacity int, policy OverflowPolicy) *Ring[T] {
+	return &Ring[T]{capacity: capacity, policy: policy}
+}
+
+func (r *Ring[T]) SetLimit(capacity int, policy OverflowPolicy) {
+	r.capacity, r.policy = capacity, policy
+}
+
+// Push добавляет x в конец. Если очередь полна: DropOldest вытесняет
+// голову, DropNewest отбрасывает x и возвращает false, Reject возвращает
+// errQueueFull.
+func (r *Ring[T]) Push(x T) (bool, error) {
+	if r.capacity > 0 && r.size >= r.capacity {
+		switch r.policy {
+		case Reject:
+			r.dropped++
+			return false, errQueueFull
+		case DropOldest:
+			old, _ := r.Pop()
+			r.dropped++
+			if r.OnDrop != nil {
+				r.OnDrop(old)
+			}
+		default:
+			r.dropped++
+			return false, nil
+		}
+	}
+	if r.size == len(r.buf) {
+		r.resize(2 * len(r.buf))
+	}
+	r.buf[(r.head+r.size)%len(r.buf)] = x
+	r.size++
+	if r.size > r.highWater {
+		r.highWater = r.size
+	}
+	return true, nil
+}
+
+func (r *Ring[T]) Pop() (T, bool) {
+	var zero T
+	if r.size == 0 {
+		return zero, false
+	}
+	x := r.buf[r.head]
+	r.buf[r.head] = zero
+	r.head = (r.head + 1) % len(r.buf)
+	r.size--
+	r.shrink()
+	return x, true
+}
+
+func (r *Ring[T]) At(i int) T {
+	if i < 0 || i >= r.size {
+		panic(fmt.Sprintf("ring index %d out of range [0:%d]", i, r.size))
+	}
+	return r.buf[(r.head+i)%len(r.buf)]
+}
+
+// Set заменяет i-й элемент, не меняя его места в очереди.
+func (r *Ring[T]) Set(i int, x T) {
+	if i < 0 || i >= r.size {
+		panic(fmt.Sprintf("ring index %d out of range [0:%d]", i, r.size))
+	}
+	r.buf[(r.head+i)%len(r.buf)] = x
+}
+
+func (r *Ring[T]) Index(match func(T) bool) int {
+	for i := 0; i < r.size; i++ {
+		if match(r.buf[(r.head+i)%len(r.buf)]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Ring[T]) RemoveAt(i int) T {
+	x := r.At(i)
+	for j := i; j < r.size-1; j++ {
+		r.buf[(r.head+j)%len(r.buf)] = r.buf[(r.head+j+1)%len(r.buf)]
+	}
+	var zero T
+	r.buf[(r.head+r.size-1)%len(r.buf)] = zero
+	r.size--
+	r.shrink()
+	return x
+}
+
+// Drain забирает все элементы и отпускает буфер.
+func (r *Ring[T]) Drain() []T {
+	if r.size == 0 {
+		return nil
+	}
+	all := make([]T, r.size)
+	for i := range all {
+		all[i] = r.buf[(r.head+i)%len(r.buf)]
+	}
+	r.buf, r.head, r.size = nil, 0, 0
+	return all
+}
+
+func (r *Ring[T]) Len() int       { return r.size }
+func (r *Ring[T]) Capacity() int  { return r.capacity }
+func (r *Ring[T]) Dropped() int   { return r.dropped }
+func (r *Ring[T]) HighWater() int { return r.highWater }
+
+func (r *Ring[T]) resize(n int) {
+	if n < ringMinBuffer {
+		n = ringMinBuffer
+	}
+	buf := make([]T, n)
+	for i := 0; i < r.size; i++ {
+		buf[i] = r.buf[(r.head+i)%len(r.buf)]
+	}
+	r.buf, r.head = buf, 0
+}
+
+func (r *Ring[T]) shrink() {
+	if len(r.buf) > ringMinBuffer && r.size <= len(r.buf)/4 {
+		r.resize(len(r.buf) / 2)
+	}
+}
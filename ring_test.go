@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing[int](0, Reject)
+	for i := 0; i < 100; i++ {
+		ok, err := r.Push(i)
+		assert.True(t, ok)
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 90; i++ {
+		x, ok := r.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, i, x)
+	}
+	assert.Equal(t, 10, r.Len())
+	assert.LessOrEqual(t, len(r.buf), 64)
+	assert.Equal(t, 100, r.HighWater())
+	assert.Equal(t, 93, r.RemoveAt(3))
+	assert.Equal(t, 94, r.At(3))
+	assert.Equal(t, 5, r.Index(func(x int) bool { return x == 96 }))
+	r.Set(4, -95)
+	assert.Equal(t, []int{90, 91, 92, 94, -95, 96, 97, 98, 99}, r.Drain())
+	_, ok := r.Pop()
+	assert.False(t, ok)
+	assert.Nil(t, r.buf)
+}
+
+func TestRingOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy OverflowPolicy
+		want   []int
+		err    error
+	}{
+		{DropNewest, []int{1, 2}, nil},
+		{DropOldest, []int{2, 3}, nil},
+		{Reject, []int{1, 2}, errQueueFull},
+	} {
+		var evicted []int
+		r := NewRing[int](2, tc.policy)
+		r.OnDrop = func(x int) { evicted = append(evicted, x) }
+		r.Push(1)
+		r.Push(2)
+		ok, err := r.Push(3)
+		assert.Equal(t, tc.policy == DropOldest, ok, tc.policy)
+		assert.ErrorIs(t, err, tc.err)
+		assert.Equal(t, 1, r.Dropped())
+		assert.Equal(t, tc.want, r.Drain(), tc.policy)
+		if tc.policy == DropOldest {
+			assert.Equal(t, []int{1}, evicted)
+		} else {
+			assert.Empty(t, evicted)
+		}
+	}
+	p, err := ParseOverflowPolicy("drop_oldest")
+	assert.NoError(t, err)
+	assert.Equal(t, DropOldest, p)
+	_, err = ParseOverflowPolicy("spill")
+	assert.ErrorIs(t, err, errUnknownOverflowPolicy)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	errUnknownRequestClass = errors.New("unknown request class")
+	errUnknownCoalesce     = errors.New("unknown coalesce policy")
+)
+
+// Класс исходящего запроса. Чем меньше значение, тем выше приоритет.
+type RequestClass int
+
+const (
+	ClassDiscovery  RequestClass = iota // WHOISHERE и ответы IAMHERE
+	ClassUser                           // команды из API и моста MQTT
+	ClassAutomation                     // SETSTATUS от триггеров, правил и расписаний
+	ClassPolling                        // GETSTATUS
+	numRequestClasses
+)
+
+var requestClassNames = [numRequestClasses]string{"discovery", "user", "automation", "polling"}
+
+func (c RequestClass) String() string {
+	if c < 0 || c >= numRequestClasses {
+		return fmt.Sprintf("class(%d)", int(c))
+	}
+	return requestClassNames[c]
+}
+
+func ParseRequestClass(s string) (RequestClass, error) {
+	for i, name := range requestClassNames {
+		if name == s {
+			return RequestClass(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", errUnknownRequestClass, s)
+}
+
+// Что делать с SETSTATUS устройству, которому команда уже стоит в очереди.
+type CoalescePolicy string
+
+const (
+	CoalesceLastWins  CoalescePolicy = "last_wins"  // новая команда заменяет старую
+	CoalesceFirstWins CoalescePolicy = "first_wins" // новая отбрасывается
+	CoalescePriority  CoalescePolicy = "priority"   // остаётся команда более важного класса, при равенстве — новая
+	CoalesceOff       CoalescePolicy = "off"        // отправляются все
+)
+
+func ParseCoalescePolicy(s string) (CoalescePolicy, error) {
+	switch p := CoalescePolicy(s); p {
+	case CoalesceLastWins, CoalesceFirstWins, CoalescePriority, CoalesceOff:
+		return p, nil
+	case "":
+		return CoalesceLastWins, nil
+	}
+	return "", fmt.Errorf("%w %q", errUnknownCoalesce, s)
+}
+
+// Ёмкость очереди класса; 0 — без ограничения. Overflow: drop_oldest,
+// drop_newest или reject (по умолчанию).
+type QueueConfig struct {
+	Capacity int    `yaml:"capacity" json:"capacity"`
+	Overflow string `yaml:"overflow" json:"overflow"`
+}
+
+func (q QueueConfig) policy() (OverflowPolicy, error) {
+	if q.Overflow == "" {
+		return Reject, nil
+	}
+	p, err := ParseOverflowPolicy(q.Overflow)
+	if err == nil && p == Block {
+		err = fmt.Errorf("%w %q: queues can't block", errUnknownOverflowPolicy, q.Overflow)
+	}
+	return p, err
+}
+
+// SchedulerConfig: MaxBatch — пакетов в одном запросе к серверу, MaxBytes —
+// байт тела запроса после base64, Limits — пакетов каждого класса в одном
+// запросе. 0 — без ограничения.
+type SchedulerConfig struct {
+	MaxBatch int                    `yaml:"max_batch" json:"max_batch"`
+	MaxBytes int                    `yaml:"max_bytes" json:"max_bytes"`
+	Limits   map[string]int         `yaml:"limits" json:"limits"`
+	Coalesce CoalescePolicy         `yaml:"coalesce" json:"coalesce"`
+	Queues   map[string]QueueConfig `yaml:"queues" json:"queues"`
+}
+
+// Scheduler раскладывает исходящие пакеты по классам и собирает из них
+// очередной запрос: сначала по одному пакету каждого непустого класса,
+// чтобы ни один класс не голодал, затем остаток по приоритету.
+type Scheduler struct {
+	queues   [numRequestClasses]*Ring[Payload]
+	limits   [numRequestClasses]int
+	maxBatch int
+	maxBytes int
+	coalesce CoalescePolicy
+	scratch  []byte
+	// пакеты, слитые с уже стоящими в очереди или отброшенные как лишние
+	dropped int
+	// OnDrop вызывается для пакета, вытесненного из переполненной очереди.
+	OnDrop func(RequestClass, Payload)
+}
+
+func NewScheduler() *Scheduler {
+	s := &Scheduler{coalesce: CoalesceLastWins}
+	for i := range s.queues {
+		class := RequestClass(i)
+		s.queues[i] = NewRing[Payload](0, Reject)
+		s.queues[i].OnDrop = func(p Payload) {
+			if s.OnDrop != nil {
+				s.OnDrop(class, p)
+			}
+		}
+	}
+	return s
+}
+
+func (s *Scheduler) SetConfig(cfg SchedulerConfig) {
+	s.maxBatch = cfg.MaxBatch
+	s.maxBytes = cfg.MaxBytes
+	s.coalesce = cfg.Coalesce
+	if s.coalesce == "" {
+		s.coalesce = CoalesceLastWins
+	}
+	s.limits = [numRequestClasses]int{}
+	for name, limit := range cfg.Limits {
+		if class, err := ParseRequestClass(name); err == nil {
+			s.limits[class] = limit
+		}
+	}
+	for i := range s.queues {
+		s.queues[i].SetLimit(0, Reject)
+	}
+	for name, q := range cfg.Queues {
+		class, err := ParseRequestClass(name)
+		if err != nil {
+			continue
+		}
+		if policy, err := q.policy(); err == nil {
+			s.queues[class].SetLimit(q.Capacity, policy)
+		}
+	}
+}
+
+// Push ставит пакет в очередь класса. GETSTATUS и SETSTATUS устройству,
+// которому такой пакет уже стоит в очереди, сливаются с ним по политике
+// coalesce. Возвращает false, если новый пакет в очередь не добавился,
+// errQueueFull, если очередь класса полна и её политика reject, и ошибку
+// кодирования для пакета, который нельзя отправить.
+func (s *Scheduler) Push(class RequestClass, p Payload) (bool, error) {
+	if err := checkPacket(p); err != nil {
+		return false, packetError(p, err)
+	}
+	if s.coalesce != CoalesceOff && (p.Cmd == GETSTATUS || p.Cmd == SETSTATUS) {
+		if queued, i := s.find(p.Cmd, p.Dst); i >= 0 {
+			replace := p.Cmd == SETSTATUS &&
+				(s.coalesce == CoalesceLastWins || (s.coalesce == CoalescePriority && class <= queued))
+			if !replace {
+				s.dropped++
+				return false, nil
+			}
+			// в своём классе новая команда занимает место старой, в другую
+			// очередь кладётся до удаления старой: если та полна, старая
+			// остаётся на своём месте
+			if class == queued {
+				s.queues[class].Set(i, p)
+			} else if ok, err := s.queues[class].Push(p); ok {
+				s.queues[queued].RemoveAt(i)
+			} else {
+				return false, err
+			}
+			s.dropped++
+			return false, nil
+		}
+	}
+	return s.queues[class].Push(p)
+}
+
+func (s *Scheduler) find(cmd byte, dst VarUint) (RequestClass, int) {
+	for class := range s.queues {
+		i := s.queues[class].Index(func(p Payload) bool { return p.Cmd == cmd && p.Dst == dst })
+		if i >= 0 {
+			return RequestClass(class), i
+		}
+	}
+	return 0, -1
+}
+
+func (s *Scheduler) Queued(cmd byte, dst VarUint) bool {
+	_, i := s.find(cmd, dst)
+	return i >= 0
+}
+
+// Lookup возвращает стоящий в очереди пакет cmd устройству dst.
+func (s *Scheduler) Lookup(cmd byte, dst VarUint) (Payload, bool) {
+	class, i := s.find(cmd, dst)
+	if i < 0 {
+		return Payload{}, false
+	}
+	return s.queues[class].At(i), true
+}
+
+func (s *Scheduler) Dropped() int {
+	return s.dropped
+}
+
+func (s *Scheduler) Len(class RequestClass) int {
+	return s.queues[class].Len()
+}
+
+func (s *Scheduler) Queue(class RequestClass) *Ring[Payload] {
+	return s.queues[class]
+}
+
+func (s *Scheduler) Size() int {
+	n := 0
+	for _, q := range s.queues {
+		n += q.Len()
+	}
+	return n
+}
+
+// длина пакета в бинарном виде: длина, payload, crc8
+func (s *Scheduler) packetLen(p Payload) int {
+	s.scratch = AppendPayload(s.scratch[:0], p)
+	return len(s.scratch) + 2
+}
+
+// Next собирает очередной запрос. Пакет, не влезший по размеру, остаётся
+// в очереди вместе со всем, что стоит за ним в его классе и что адресовано
+// тому же устройству, так что порядок пакетов одному устройству не
+// меняется. Пакет больше MaxBytes уходит один.
+func (s *Scheduler) Next() []Payload {
+	var batch []Payload
+	var taken [numRequestClasses]int
+	var stopped [numRequestClasses]bool
+	var deferred map[VarUint]bool
+	binLen := 0
+	take := func(class RequestClass, n int) {
+		q := s.queues[class]
+		for ; n > 0 && q.Len() > 0 && !stopped[class]; n-- {
+			if s.maxBatch > 0 && len(batch) >= s.maxBatch {
+				return
+			}
+			if s.limits[class] > 0 && taken[class] >= s.limits[class] {
+				return
+			}
+			p := q.At(0)
+			if deferred[p.Dst] {
+				stopped[class] = true
+				return
+			}
+			if s.maxBytes > 0 {
+				size := s.packetLen(p)
+				if len(batch) > 0 && base64.RawURLEncoding.EncodedLen(binLen+size) > s.maxBytes {
+					if deferred == nil {
+						deferred = make(map[VarUint]bool)
+					}
+					deferred[p.Dst] = true
+					stopped[class] = true
+					return
+				}
+				binLen += size
+			}
+			q.Pop()
+			batch = append(batch, p)
+			taken[class]++
+		}
+	}
+	for class := RequestClass(0); class < numRequestClasses; class++ {
+		take(class, 1)
+	}
+	for class := RequestClass(0); class < numRequestClasses; class++ {
+		take(class, s.queues[class].Len())
+	}
+	return batch
+}
+
+// GetAllAndClear забирает все пакеты без учёта ограничений, по приоритету.
+func (s *Scheduler) GetAllAndClear() []Payload {
+	var all []Payload
+	for _, q := range s.queues {
+		all = append(all, q.Drain()...)
+	}
+	return all
+}
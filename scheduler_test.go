@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func push(s *Scheduler, class RequestClass, p Payload) bool {
+	queued, err := s.Push(class, p)
+	if err != nil {
+		panic(err)
+	}
+	return queued
+}
+
+func TestSchedulerPriorityAndLimits(t *testing.T) {
+	s := NewScheduler()
+	for i := VarUint(1); i <= 5; i++ {
+		s.Push(ClassPolling, Payload{Dst: 100 + i, Cmd: GETSTATUS})
+	}
+	s.Push(ClassAutomation, Payload{Dst: 20, Cmd: SETSTATUS})
+	s.Push(ClassUser, Payload{Dst: 10, Cmd: SETSTATUS})
+	s.Push(ClassDiscovery, Payload{Dst: 1, Cmd: IAMHERE})
+
+	dsts := func(batch []Payload) []VarUint {
+		var d []VarUint
+		for _, p := range batch {
+			d = append(d, p.Dst)
+		}
+		return d
+	}
+
+	// без ограничений всё уходит одним запросом, по приоритету
+	assert.Equal(t, []VarUint{1, 10, 20, 101, 102, 103, 104, 105}, dsts(s.Next()))
+	assert.Empty(t, s.Next())
+
+	s.SetConfig(SchedulerConfig{MaxBatch: 4, Limits: map[string]int{"polling": 2}})
+	for i := VarUint(1); i <= 5; i++ {
+		s.Push(ClassPolling, Payload{Dst: 100 + i, Cmd: GETSTATUS})
+	}
+	s.Push(ClassUser, Payload{Dst: 10, Cmd: SETSTATUS})
+	s.Push(ClassUser, Payload{Dst: 11, Cmd: SETSTATUS})
+	s.Push(ClassUser, Payload{Dst: 12, Cmd: SETSTATUS})
+	s.Push(ClassUser, Payload{Dst: 13, Cmd: SETSTATUS})
+	// опрос получает место даже при потоке команд пользователя
+	assert.Equal(t, []VarUint{10, 101, 11, 12}, dsts(s.Next()))
+	s.Push(ClassDiscovery, Payload{Dst: 1, Cmd: IAMHERE})
+	assert.Equal(t, []VarUint{1, 13, 102, 103}, dsts(s.Next()))
+	assert.Equal(t, []VarUint{104, 105}, dsts(s.Next()))
+	assert.Zero(t, s.Size())
+}
+
+func TestParseRequestClass(t *testing.T) {
+	class, err := ParseRequestClass("automation")
+	assert.NoError(t, err)
+	assert.Equal(t, ClassAutomation, class)
+	assert.Equal(t, "automation", class.String())
+	_, err = ParseRequestClass("urgent")
+	assert.ErrorIs(t, err, errUnknownRequestClass)
+}
+
+func TestSchedulerCoalesce(t *testing.T) {
+	set := func(dst VarUint, on Flag) Payload {
+		return Payload{Dst: dst, Cmd: SETSTATUS, CmdBody: on}
+	}
+	bodies := func(s *Scheduler) []Flag {
+		var b []Flag
+		for _, p := range s.GetAllAndClear() {
+			if p.Cmd == SETSTATUS {
+				b = append(b, p.CmdBody.(Flag))
+			}
+		}
+		return b
+	}
+	for _, tc := range []struct {
+		policy CoalescePolicy
+		want   []Flag
+	}{
+		{CoalesceLastWins, []Flag{false}},
+		{CoalesceFirstWins, []Flag{true}},
+		{CoalescePriority, []Flag{true}},
+		{CoalesceOff, []Flag{true, false}},
+	} {
+		s := NewScheduler()
+		s.SetConfig(SchedulerConfig{Coalesce: tc.policy})
+		assert.True(t, push(s, ClassUser, set(5, true)))
+		queued := push(s, ClassAutomation, set(5, false))
+		assert.Equal(t, tc.policy == CoalesceOff, queued, tc.policy)
+		assert.Equal(t, tc.want, bodies(s), tc.policy)
+	}
+
+	s := NewScheduler()
+	assert.True(t, push(s, ClassPolling, Payload{Dst: 5, Cmd: GETSTATUS}))
+	assert.False(t, push(s, ClassPolling, Payload{Dst: 5, Cmd: GETSTATUS}))
+	assert.True(t, push(s, ClassPolling, Payload{Dst: 6, Cmd: GETSTATUS}))
+	assert.Equal(t, 2, s.Size())
+	assert.Equal(t, 1, s.Dropped())
+}
+
+func TestSchedulerCoalesceKeepsPosition(t *testing.T) {
+	s := NewScheduler()
+	for dst := VarUint(4); dst <= 6; dst++ {
+		assert.True(t, push(s, ClassUser, Payload{Dst: dst, Cmd: SETSTATUS, CmdBody: Flag(true)}))
+	}
+	assert.False(t, push(s, ClassUser, Payload{Dst: 5, Cmd: SETSTATUS, CmdBody: Flag(false)}))
+
+	// заменённая команда устройству 5 осталась второй, а не ушла в хвост
+	sent := s.GetAllAndClear()
+	require.Len(t, sent, 3)
+	for i, dst := range []VarUint{4, 5, 6} {
+		assert.Equal(t, dst, sent[i].Dst)
+	}
+	assert.Equal(t, Flag(false), sent[1].CmdBody)
+	assert.Equal(t, 1, s.Dropped())
+}
+
+func TestSchedulerCoalesceIntoFullQueue(t *testing.T) {
+	s := NewScheduler()
+	s.SetConfig(SchedulerConfig{Queues: map[string]QueueConfig{"user": {Capacity: 1}}})
+	assert.True(t, push(s, ClassUser, Payload{Dst: 4, Cmd: SETSTATUS, CmdBody: Flag(true)}))
+	assert.True(t, push(s, ClassAutomation, Payload{Dst: 5, Cmd: SETSTATUS, CmdBody: Flag(true)}))
+	assert.True(t, push(s, ClassAutomation, Payload{Dst: 6, Cmd: SETSTATUS, CmdBody: Flag(true)}))
+
+	// заменить команду автоматизации пользовательской некуда: старая
+	// остаётся первой в своей очереди
+	queued, err := s.Push(ClassUser, Payload{Dst: 5, Cmd: SETSTATUS, CmdBody: Flag(false)})
+	assert.False(t, queued)
+	assert.ErrorIs(t, err, errQueueFull)
+	assert.Zero(t, s.Dropped())
+	q := s.Queue(ClassAutomation)
+	require.Equal(t, 2, q.Len())
+	assert.Equal(t, VarUint(5), q.At(0).Dst)
+	assert.Equal(t, Flag(true), q.At(0).CmdBody)
+}
+
+func TestSetStatusKnownState(t *testing.T) {
+	hub := newTestHub()
+	hub.wr = CreateWaitRequests()
+	hub.SaveDevice("LAMP01", 5, LAMP, nil)
+	hub.SaveStatus(5, Flag(true))
+
+	require.NoError(t, hub.SetDeviceStatus("LAMP01", true))
+	assert.Zero(t, hub.requests.Size())
+	assert.Zero(t, hub.wr.size)
+
+	// выключить и сразу включить обратно: в очереди остаётся одна команда
+	require.NoError(t, hub.SetDeviceStatus("LAMP01", false))
+	require.NoError(t, hub.SetDeviceStatus("LAMP01", true))
+	sent := hub.requests.GetAllAndClear()
+	require.Len(t, sent, 1)
+	assert.Equal(t, Flag(true), sent[0].CmdBody)
+	assert.Equal(t, 1, hub.wr.size)
+
+	// команда ушла, ответа ещё нет — состояние устройства неизвестно
+	require.NoError(t, hub.SetDeviceStatus("LAMP01", true))
+	assert.Equal(t, 1, hub.requests.Size())
+}
+
+func TestSchedulerQueueOverflow(t *testing.T) {
+	hub := newTestHub()
+	hub.wr = CreateWaitRequests()
+	hub.requests.SetConfig(SchedulerConfig{Queues: map[string]QueueConfig{
+		"user":    {Capacity: 1},
+		"polling": {Capacity: 2, Overflow: "drop_oldest"},
+	}})
+	hub.SaveDevice("LAMP01", 10, LAMP, nil)
+	hub.SaveDevice("LAMP02", 11, LAMP, nil)
+	hub.SaveDevice("LAMP03", 12, LAMP, nil)
+	require.NoError(t, hub.SetDeviceStatus("LAMP01", true))
+	assert.ErrorIs(t, hub.SetDeviceStatus("LAMP02", true), errQueueFull)
+
+	for _, dev := range hub.Devices() {
+		hub.pushGetStatus(dev)
+	}
+	// GETSTATUS к LAMP01 вытеснен, ждём ответа только на SETSTATUS ему и на опросы 11, 12
+	assert.Equal(t, 2, hub.requests.Len(ClassPolling))
+	assert.Equal(t, 1, hub.requests.Queue(ClassPolling).Dropped())
+	assert.Equal(t, 3, hub.wr.size)
+	assert.True(t, hub.wr.Pending(STATUS, 10))
+}
+
+func TestSchedulerMaxBytes(t *testing.T) {
+	set := func(dst VarUint) Payload {
+		return Payload{Src: 1, Dst: dst, Serial: 1, DevType: LAMP, Cmd: SETSTATUS, CmdBody: Flag(true)}
+	}
+	get := func(dst VarUint) Payload { return Payload{Src: 1, Dst: dst, Serial: 1, DevType: LAMP, Cmd: GETSTATUS} }
+	batches := func(s *Scheduler) [][]string {
+		var all [][]string
+		for s.Size() > 0 {
+			var names []string
+			batch := s.Next()
+			body, err := serializePayloadsToBase64URLEncoded(batch)
+			require.NoError(t, err)
+			if len(batch) > 1 {
+				assert.LessOrEqual(t, len(body), s.maxBytes)
+			}
+			for _, p := range batch {
+				names = append(names, fmt.Sprintf("%s:%d", CmdName(p.Cmd), p.Dst))
+			}
+			all = append(all, names)
+		}
+		return all
+	}
+
+	// SETSTATUS — 8 байт, GETSTATUS — 7, 16 байт — 22 символа base64
+	s := NewScheduler()
+	s.SetConfig(SchedulerConfig{MaxBytes: 22})
+	push(s, ClassUser, set(10))
+	push(s, ClassUser, set(11))
+	push(s, ClassPolling, get(10))
+	push(s, ClassPolling, get(12))
+	assert.Equal(t, [][]string{
+		{"SETSTATUS:10", "GETSTATUS:10"},
+		{"SETSTATUS:11", "GETSTATUS:12"},
+	}, batches(s))
+
+	// отложенный пакет устройству 11 задерживает и следующие ему
+	s.SetConfig(SchedulerConfig{MaxBytes: 11})
+	push(s, ClassUser, set(10))
+	push(s, ClassUser, set(11))
+	push(s, ClassPolling, get(11))
+	push(s, ClassPolling, get(12))
+	assert.Equal(t, [][]string{
+		{"SETSTATUS:10"},
+		{"SETSTATUS:11"},
+		{"GETSTATUS:11"},
+		{"GETSTATUS:12"},
+	}, batches(s))
+
+	// пакет больше ограничения уходит один
+	s.SetConfig(SchedulerConfig{MaxBytes: 4})
+	push(s, ClassUser, set(10))
+	push(s, ClassUser, set(11))
+	assert.Equal(t, [][]string{{"SETSTATUS:10"}, {"SETSTATUS:11"}}, batches(s))
+}
+
+// lockedBuffer собирает лог, в который могут писать и чужие горутины.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDiscoveryReplyDropLogged(t *testing.T) {
+	var log lockedBuffer
+	logger.SetOutput(&log)
+	defer logger.SetOutput(os.Stderr)
+
+	hub := newTestHub()
+	hub.wr = CreateWaitRequests()
+	hub.requests.SetConfig(SchedulerConfig{Queues: map[string]QueueConfig{"discovery": {Capacity: 1}}})
+	hub.processingPayload(Payload{Src: 5, Dst: ALL, Serial: 1, DevType: LAMP, Cmd: WHOISHERE, CmdBody: DeviceCmdBody{DevName: "LAMP01"}})
+	hub.processingPayload(Payload{Src: 6, Dst: ALL, Serial: 1, DevType: LAMP, Cmd: WHOISHERE, CmdBody: DeviceCmdBody{DevName: "LAMP02"}})
+
+	require.Equal(t, 1, hub.requests.Len(ClassDiscovery))
+	assert.Equal(t, VarUint(5), hub.requests.Queue(ClassDiscovery).At(0).Dst)
+	assert.Contains(t, log.String(), "discovery queue: dropping IAMHERE to 0x6")
+}
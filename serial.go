@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Последовательная линия (RS-485): кадр — байт синхронизации, длина,
+// payload, CRC8. После порчи данных приёмник ищет следующий байт
+// синхронизации, за которым идёт кадр с верной CRC. Как и у UDP, ответом
+// на Exchange считается всё, что пришло за transport.window.
+//
+// serial:///dev/ttyUSB0?baud=115200
+const (
+	serialSync        = 0x7E
+	defaultSerialBaud = 115200
+)
+
+var errSerialUnsupported = errors.New("serial transport is not supported on this platform")
+
+// serialPort — открытый порт с поддержкой дедлайнов чтения.
+type serialPort interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+type serialTransport struct {
+	path string
+	baud int
+	cfg  TransportConfig
+	port serialPort
+	buf  []byte
+	in   []byte // принятые, ещё не разобранные байты
+	rbuf []byte
+}
+
+func newSerialTransport(u *url.URL, cfg TransportConfig) (Transport, error) {
+	path := u.Path
+	if path == "" {
+		path = u.Opaque
+	}
+	if path == "" {
+		return nil, fmt.Errorf("serial: missing device path in %q", u.String())
+	}
+	baud := defaultSerialBaud
+	if s := u.Query().Get("baud"); s != "" {
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("serial: bad baud %q", s)
+		}
+		baud = b
+	}
+	return &serialTransport{path: path, baud: baud, cfg: cfg, rbuf: make([]byte, 1024)}, nil
+}
+
+func appendSerialFrame(dst []byte, p Payload) ([]byte, error) {
+	start := len(dst)
+	dst, err := appendPacket(append(dst, serialSync), p)
+	if err != nil {
+		return dst[:start], err
+	}
+	return dst, nil
+}
+
+// nextSerialFrame ищет в in первый целый кадр с верной CRC. Возвращает
+// кадр без байта синхронизации и сколько байт in уже можно выбросить.
+// Байт синхронизации пропускается, только когда его кадр принят целиком
+// и CRC не сошлась или длина недопустима: иначе случайно верная CRC
+// внутри ещё не дочитанного кадра выбросила бы настоящий кадр.
+func nextSerialFrame(in []byte) (frame []byte, consumed int) {
+	for i := 0; i < len(in); i++ {
+		if in[i] != serialSync {
+			continue
+		}
+		if i+1 >= len(in) {
+			return nil, i
+		}
+		length := int(in[i+1])
+		if length == 0 {
+			continue
+		}
+		end := i + 2 + length + 1
+		if end > len(in) {
+			return nil, i
+		}
+		if calculateCRC8(in[i+2:end-1]) == in[end-1] {
+			return in[i+1 : end], end
+		}
+	}
+	return nil, len(in)
+}
+
+func (t *serialTransport) Exchange(ctx context.Context, requests []Payload) ([]Payload, error) {
+	if t.port == nil {
+		port, err := openSerial(t.path, t.baud)
+		if err != nil {
+			return nil, &TransportError{Op: "open " + t.path, Err: err}
+		}
+		t.port = port
+		logf(LogInfo, "serial: opened %s at %d baud", t.path, t.baud)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func(port serialPort) {
+		select {
+		case <-ctx.Done():
+			port.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}(t.port)
+
+	msg := t.buf[:0]
+	for _, p := range requests {
+		if p.Cmd == 0 {
+			continue
+		}
+		var err error
+		if msg, err = appendSerialFrame(msg, p); err != nil {
+			warnSkipped("serial", packetError(p, err))
+		}
+	}
+	t.buf = msg
+	if len(msg) > 0 {
+		if _, err := t.port.Write(msg); err != nil {
+			t.drop()
+			return nil, &TransportError{Op: "write", Err: err}
+		}
+	}
+	payloads, err := t.receive()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		t.drop()
+	}
+	return payloads, err
+}
+
+// receive, как и у UDP, держит один общий срок на весь приём: шум на
+// линии его не продлевает, а после первых пакетов он только сокращается.
+func (t *serialTransport) receive() ([]Payload, error) {
+	var payloads []Payload
+	deadline := time.Now().Add(time.Duration(t.cfg.Window))
+	for {
+		t.port.SetReadDeadline(deadline)
+		n, err := t.port.Read(t.rbuf)
+		t.in = append(t.in, t.rbuf[:n]...)
+		for {
+			frame, consumed := nextSerialFrame(t.in)
+			t.in = t.in[consumed:]
+			if frame == nil {
+				break
+			}
+			payloads = append(payloads, deserializeFromBinaryFormToPayloads(frame)...)
+		}
+		if len(t.in) == 0 {
+			t.in = nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return payloads, nil
+		}
+		if err != nil {
+			return payloads, &TransportError{Op: "read", Err: err}
+		}
+		if soon := time.Now().Add(10 * time.Millisecond); len(payloads) > 0 && soon.Before(deadline) {
+			deadline = soon
+		}
+	}
+}
+
+func (t *serialTransport) drop() {
+	if t.port != nil {
+		t.port.Close()
+		t.port = nil
+		t.in = nil
+	}
+}
+
+func (t *serialTransport) Close() error {
+	if t.port == nil {
+		return nil
+	}
+	err := t.port.Close()
+	t.port = nil
+	return err
+}
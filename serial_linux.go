@@ -0,0 +1,75 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const termiosCBAUD = 0x100f
+
+var serialBauds = map[int]uint32{
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openSerial открывает порт без управляющего терминала и переводит его в
+// сырой режим 8N1. Файл остаётся неблокирующим, поэтому работают дедлайны.
+func openSerial(path string, baud int) (serialPort, error) {
+	speed, ok := serialBauds[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := makeRaw(f, speed); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func makeRaw(f *os.File, speed uint32) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ioErr error
+	err = rc.Control(func(fd uintptr) {
+		var tio syscall.Termios
+		if ioErr = ioctl(fd, syscall.TCGETS, unsafe.Pointer(&tio)); ioErr != nil {
+			return
+		}
+		tio.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+			syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+		tio.Oflag &^= syscall.OPOST
+		tio.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		tio.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | termiosCBAUD
+		tio.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+		tio.Ispeed, tio.Ospeed = speed, speed
+		tio.Cc[syscall.VMIN], tio.Cc[syscall.VTIME] = 1, 0
+		ioErr = ioctl(fd, syscall.TCSETS, unsafe.Pointer(&tio))
+	})
+	if err != nil {
+		return err
+	}
+	return ioErr
+}
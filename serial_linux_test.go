@@ -0,0 +1,115 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openPTY возвращает ведущую сторону псевдотерминала и путь к ведомой.
+func openPTY(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	rc, err := master.SyscallConn()
+	require.NoError(t, err)
+	var n uint32
+	var ioErr error
+	require.NoError(t, rc.Control(func(fd uintptr) {
+		var unlock int32
+		if ioErr = ioctl(fd, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); ioErr == nil {
+			ioErr = ioctl(fd, syscall.TIOCGPTN, unsafe.Pointer(&n))
+		}
+	}))
+	require.NoError(t, ioErr)
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestSerialTransportPTY(t *testing.T) {
+	master, slave := openPTY(t)
+	cfg := defaultTransportConfig()
+	cfg.Window = Duration(time.Second)
+	tr, err := NewTransport("serial://"+slave+"?baud=9600", cfg)
+	require.NoError(t, err)
+	defer tr.Close()
+
+	status := Payload{Src: 7, Dst: 1, Serial: 2, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(true)}
+	good := serialFrame(t, status)
+	corrupt := serialFrame(t, status)
+	corrupt[3] ^= 0x01
+	received := make(chan []Payload, 1)
+	go func() {
+		buf := make([]byte, 256)
+		var in []byte
+		for {
+			n, err := master.Read(buf)
+			if err != nil {
+				return
+			}
+			in = append(in, buf[:n]...)
+			if frame, consumed := nextSerialFrame(in); frame != nil {
+				in = in[consumed:]
+				received <- deserializeFromBinaryFormToPayloads(frame)
+				break
+			}
+		}
+		master.Write([]byte{0x00, serialSync, 0x01, 0x42, 0x00})
+		master.Write(corrupt)
+		master.Write(good[:4])
+		time.Sleep(20 * time.Millisecond)
+		master.Write(good[4:])
+	}()
+
+	get := Payload{Src: 1, Dst: 7, Serial: 2, DevType: LAMP, Cmd: GETSTATUS}
+	got, err := tr.Exchange(context.Background(), []Payload{get})
+	require.NoError(t, err)
+	assert.Equal(t, []Payload{get}, <-received)
+	assert.Equal(t, []Payload{status}, got)
+
+	_, err = NewTransport("serial:///dev/null?baud=fast", cfg)
+	assert.Error(t, err)
+	tr2, err := NewTransport("serial:///dev/null?baud=12345", cfg)
+	require.NoError(t, err)
+	_, err = tr2.Exchange(context.Background(), nil)
+	var transportErr *TransportError
+	assert.ErrorAs(t, err, &transportErr)
+}
+
+func TestSerialTransportNoisyLine(t *testing.T) {
+	master, slave := openPTY(t)
+	cfg := defaultTransportConfig()
+	cfg.Window = Duration(50 * time.Millisecond)
+	tr, err := NewTransport("serial://"+slave, cfg)
+	require.NoError(t, err)
+	defer tr.Close()
+
+	// шум идёт непрерывно и не должен продлевать окно
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(2 * time.Millisecond):
+			}
+			master.Write([]byte{0x00, 0x13})
+		}
+	}()
+	start := time.Now()
+	got, err := tr.Exchange(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func openSerial(path string, baud int) (serialPort, error) {
+	return nil, errSerialUnsupported
+}
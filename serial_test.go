@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serialFrame(t *testing.T, p Payload) []byte {
+	frame, err := appendSerialFrame(nil, p)
+	require.NoError(t, err)
+	return frame
+}
+
+func TestSerialResync(t *testing.T) {
+	status := Payload{Src: 7, Dst: 1, Serial: 2, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(true)}
+	good := serialFrame(t, status)
+	corrupt := serialFrame(t, status)
+	corrupt[len(corrupt)-1] ^= 0xFF
+
+	var in []byte
+	// шум: байт синхронизации с нулевой длиной и целый кадр с неверной CRC
+	in = append(in, 0x00, serialSync, 0x00, serialSync, 0x02, 0x13, 0x55, 0x00)
+	in = append(in, corrupt...)
+	in = append(in, good...)
+	in = append(in, good[:3]...) // недочитанный кадр
+
+	var got []Payload
+	for {
+		frame, consumed := nextSerialFrame(in)
+		in = in[consumed:]
+		if frame == nil {
+			break
+		}
+		got = append(got, deserializeFromBinaryFormToPayloads(frame)...)
+	}
+	assert.Equal(t, []Payload{status}, got)
+	assert.Equal(t, good[:3], in)
+
+	in = append(in, good[3:]...)
+	frame, consumed := nextSerialFrame(in)
+	assert.Equal(t, good[1:], frame)
+	assert.Equal(t, len(good), consumed)
+}
+
+func TestSerialIncompleteFrameNotSkipped(t *testing.T) {
+	// имя устройства само выглядит как кадр с верной CRC
+	inner := serialFrame(t, Payload{Src: 9, Dst: 1, Serial: 3, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(true)})
+	outer := serialFrame(t, Payload{Src: 7, Dst: ALL, Serial: 2, DevType: LAMP, Cmd: IAMHERE, CmdBody: DeviceCmdBody{DevName: string(inner)}})
+
+	in := outer[:len(outer)-1]
+	frame, consumed := nextSerialFrame(in)
+	assert.Nil(t, frame)
+	assert.Zero(t, consumed)
+
+	frame, consumed = nextSerialFrame(outer)
+	assert.Equal(t, outer[1:], frame)
+	assert.Equal(t, len(outer), consumed)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Пакеты по TCP идут без base64, теми же кадрами длина, payload, crc8.
+// Пакеты одного запроса или ответа склеены в сообщение с двухбайтовой
+// длиной (big endian) впереди; сообщение нулевой длины — пустой опрос.
+// Сервер, закрывший запись на границе сообщения, завершает сессию.
+const maxTCPMessage = 0xFFFF
+
+var errMessageTooLarge = errors.New("message too large")
+
+type tcpTransport struct {
+	addr string
+	cfg  TransportConfig
+	conn *net.TCPConn
+	buf  []byte
+}
+
+func newTCPTransport(u *url.URL, cfg TransportConfig) (Transport, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("tcp: missing host:port in %q", u.String())
+	}
+	return &tcpTransport{addr: u.Host, cfg: cfg}, nil
+}
+
+func (t *tcpTransport) connect(ctx context.Context) error {
+	if t.conn != nil {
+		return nil
+	}
+	d := net.Dialer{Timeout: time.Duration(t.cfg.DialTimeout), KeepAlive: time.Duration(t.cfg.KeepAlive)}
+	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return err
+	}
+	t.conn = conn.(*net.TCPConn)
+	logf(LogInfo, "tcp: connected to %s", t.addr)
+	return nil
+}
+
+// Обрыв уже открытого соединения транспорт переживает сам: подключается
+// заново и один раз повторяет сообщение. Ошибка на свежем соединении или
+// таймаут — ошибка транспорта; следующий Exchange (повтор из retrying)
+// подключится заново.
+func (t *tcpTransport) Exchange(ctx context.Context, requests []Payload) ([]Payload, error) {
+	msg, skipped := appendPackets(append(t.buf[:0], 0, 0), requests)
+	t.buf = msg
+	warnSkipped("tcp", skipped...)
+	if len(msg)-2 > maxTCPMessage {
+		return nil, &ProtocolError{Op: "encode requests", Err: fmt.Errorf("%w: %d bytes", errMessageTooLarge, len(msg)-2)}
+	}
+	binary.BigEndian.PutUint16(msg, uint16(len(msg)-2))
+	for reconnected := false; ; reconnected = true {
+		reused := t.conn != nil
+		payloads, err := t.exchange(ctx, msg)
+		if err == nil || errors.Is(err, errSessionEnded) {
+			return payloads, err
+		}
+		t.drop()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var netErr net.Error
+		if !reused || reconnected || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil, err
+		}
+		logf(LogWarn, "tcp: %v, reconnecting", err)
+	}
+}
+
+func (t *tcpTransport) exchange(ctx context.Context, msg []byte) ([]Payload, error) {
+	if err := t.connect(ctx); err != nil {
+		return nil, &TransportError{Op: "dial", Err: err}
+	}
+	// отмена контекста прерывает чтение и запись
+	done := make(chan struct{})
+	defer close(done)
+	go func(conn net.Conn) {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}(t.conn)
+	return t.roundTrip(msg)
+}
+
+func (t *tcpTransport) roundTrip(msg []byte) ([]Payload, error) {
+	if t.cfg.ReadTimeout > 0 {
+		t.conn.SetWriteDeadline(time.Now().Add(time.Duration(t.cfg.ReadTimeout)))
+	}
+	if _, err := t.conn.Write(msg); err != nil {
+		return nil, &TransportError{Op: "write", Err: err}
+	}
+	if t.cfg.ReadTimeout > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(time.Duration(t.cfg.ReadTimeout)))
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(t.conn, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errSessionEnded
+		}
+		return nil, &TransportError{Op: "read", Err: err}
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(t.conn, body); err != nil {
+		return nil, &TransportError{Op: "read", Err: err}
+	}
+	return deserializeFromBinaryFormToPayloads(body), nil
+}
+
+func (t *tcpTransport) drop() {
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
+
+// Close закрывает запись, чтобы сервер увидел конец сессии, затем соединение.
+func (t *tcpTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	t.conn.CloseWrite()
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTCPMessage(t *testing.T, conn net.Conn) []Payload {
+	var header [2]byte
+	_, err := io.ReadFull(conn, header[:])
+	require.NoError(t, err)
+	body := make([]byte, binary.BigEndian.Uint16(header[:]))
+	_, err = io.ReadFull(conn, body)
+	require.NoError(t, err)
+	return deserializeFromBinaryFormToPayloads(body)
+}
+
+func writeTCPMessage(t *testing.T, conn net.Conn, payloads ...Payload) {
+	msg, skipped := appendPackets([]byte{0, 0}, payloads)
+	require.Empty(t, skipped)
+	binary.BigEndian.PutUint16(msg, uint16(len(msg)-2))
+	_, err := conn.Write(msg)
+	require.NoError(t, err)
+}
+
+func TestTCPTransportSession(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []Payload, 8)
+	go func() {
+		// первое соединение сервер сбрасывает (RST) после первого ответа
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		received <- readTCPMessage(t, conn)
+		writeTCPMessage(t, conn, iamhere(5, LAMP, "LAMP01"))
+		received <- readTCPMessage(t, conn)
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+
+		// после переподключения — ещё один обмен и закрытие записи
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received <- readTCPMessage(t, conn)
+		writeTCPMessage(t, conn, tick(100))
+		received <- readTCPMessage(t, conn)
+		conn.(*net.TCPConn).CloseWrite()
+		io.Copy(io.Discard, conn)
+	}()
+
+	hub := newTestHub()
+	hub.Url = "tcp://" + ln.Addr().String()
+	hub.cfg = &Config{Retry: RetryConfig{Attempts: 2}, Transport: defaultTransportConfig()}
+	err = hub.Start(context.Background())
+	assert.ErrorIs(t, err, errSessionEnded)
+	code, _ := exitCode(err)
+	assert.Equal(t, ExitOK, code)
+
+	first := <-received
+	require.Len(t, first, 1)
+	assert.Equal(t, WHOISHERE, first[0].Cmd)
+	getStatus := <-received
+	require.Len(t, getStatus, 1)
+	assert.Equal(t, GETSTATUS, getStatus[0].Cmd)
+	// тот же запрос повторён по новому соединению
+	assert.Equal(t, getStatus, <-received)
+	assert.Empty(t, <-received)
+	_, ok := hub.DeviceByName("LAMP01")
+	assert.True(t, ok)
+}
+
+func TestTCPTransportReconnectsByDefault(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []Payload, 8)
+	go func() {
+		// сервер сбрасывает соединение, не ответив на второй запрос
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		received <- readTCPMessage(t, conn)
+		writeTCPMessage(t, conn, iamhere(5, LAMP, "LAMP01"))
+		received <- readTCPMessage(t, conn)
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received <- readTCPMessage(t, conn)
+		conn.(*net.TCPConn).CloseWrite()
+		io.Copy(io.Discard, conn)
+	}()
+
+	// повторов retry по умолчанию нет: переподключается сам транспорт
+	hub := newTestHub()
+	hub.Url = "tcp://" + ln.Addr().String()
+	hub.cfg = defaultConfig()
+	require.Zero(t, hub.cfg.Retry.Attempts)
+	err = hub.Start(context.Background())
+	assert.ErrorIs(t, err, errSessionEnded)
+
+	assert.Equal(t, WHOISHERE, (<-received)[0].Cmd)
+	getStatus := <-received
+	require.Len(t, getStatus, 1)
+	assert.Equal(t, GETSTATUS, getStatus[0].Cmd)
+	select {
+	case again := <-received:
+		assert.Equal(t, getStatus, again)
+	case <-time.After(time.Second):
+		t.Fatal("transport did not reconnect")
+	}
+}
+
+func TestTCPTransportErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	cfg := defaultTransportConfig()
+	cfg.ReadTimeout = Duration(50 * time.Millisecond)
+	tr, err := NewTransport("tcp://"+addr, cfg)
+	require.NoError(t, err)
+	defer tr.Close()
+	_, err = tr.Exchange(context.Background(), nil)
+	var transportErr *TransportError
+	require.ErrorAs(t, err, &transportErr)
+	assert.Equal(t, "read", transportErr.Op)
+
+	ln.Close()
+	_, err = tr.Exchange(context.Background(), nil)
+	require.ErrorAs(t, err, &transportErr)
+	assert.Equal(t, "dial", transportErr.Op)
+
+	_, err = NewTransport("carrier-pigeon://coop", cfg)
+	assert.ErrorIs(t, err, errUnsupportedScheme)
+}
+
+func TestTCPTransportSkipsUnencodable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	received := make(chan []Payload, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received <- readTCPMessage(t, conn)
+		writeTCPMessage(t, conn)
+	}()
+
+	tr, err := NewTransport("tcp://"+ln.Addr().String(), defaultTransportConfig())
+	require.NoError(t, err)
+	defer tr.Close()
+	good := Payload{Src: 1, Dst: 5, Serial: 2, DevType: LAMP, Cmd: GETSTATUS}
+	skipped := skippedPackets.Load()
+	_, err = tr.Exchange(context.Background(), []Payload{{Src: 1, Dst: ALL + 1, Serial: 1, DevType: LAMP, Cmd: GETSTATUS}, good})
+	require.NoError(t, err)
+	assert.Equal(t, []Payload{good}, <-received)
+	assert.Equal(t, skipped+1, skippedPackets.Load())
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errSessionEnded      = errors.New("session ended by server")
+	errUnsupportedScheme = errors.New("unsupported transport scheme")
+)
+
+// Transport доставляет пакеты хаба на сервер и возвращает ответные.
+// Exchange вызывается из одной горутины, следующий — только после
+// обработки ответа на предыдущий. Пустой список — опрос без пакетов.
+// Завершение сессии сервером — ошибка, для которой errors.Is(err,
+// statusCode204) или errors.Is(err, errSessionEnded).
+type Transport interface {
+	Exchange(ctx context.Context, requests []Payload) ([]Payload, error)
+	Close() error
+}
+
+// Пакеты, пропущенные транспортами при кодировании, для метрик хаба.
+var skippedPackets atomic.Uint64
+
+// warnSkipped: пакет, который не кодируется, не роняет обмен — он
+// пропускается с предупреждением, остальные уходят.
+func warnSkipped(transport string, skipped ...error) {
+	for _, err := range skipped {
+		skippedPackets.Add(1)
+		logf(LogWarn, "%s: skipping packet: %v", transport, err)
+	}
+}
+
+// ExchangeFunc — транспорт из одной функции, для тестов и обёрток.
+type ExchangeFunc func(ctx context.Context, requests []Payload) ([]Payload, error)
+
+func (f ExchangeFunc) Exchange(ctx context.Context, requests []Payload) ([]Payload, error) {
+	return f(ctx, requests)
+}
+
+func (f ExchangeFunc) Close() error { return nil }
+
+type TransportConfig struct {
+	DialTimeout Duration `yaml:"dial_timeout" json:"dial_timeout"`
+	ReadTimeout Duration `yaml:"read_timeout" json:"read_timeout"`
+	KeepAlive   Duration `yaml:"keepalive" json:"keepalive"`
+	Window      Duration `yaml:"window" json:"window"` // udp: сколько ждать ответа
+}
+
+func defaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		DialTimeout: Duration(5 * time.Second),
+		ReadTimeout: Duration(30 * time.Second),
+		KeepAlive:   Duration(15 * time.Second),
+		Window:      Duration(100 * time.Millisecond),
+	}
+}
+
+var transportSchemes = map[string]func(u *url.URL, cfg TransportConfig) (Transport, error){
+	"http":   newHTTPTransport,
+	"https":  newHTTPTransport,
+	"tcp":    newTCPTransport,
+	"udp":    newUDPTransport,
+	"serial": newSerialTransport,
+}
+
+// NewTransport выбирает транспорт по схеме URL сервера.
+func NewTransport(rawURL string, cfg TransportConfig) (Transport, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	open, ok := transportSchemes[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnsupportedScheme, u.Scheme)
+	}
+	return open(u, cfg)
+}
+
+// HTTP: пакеты в base64 в теле POST, 204 — конец сессии.
+type httpTransport struct {
+	url string
+	buf []byte
+}
+
+func newHTTPTransport(u *url.URL, cfg TransportConfig) (Transport, error) {
+	return &httpTransport{url: u.String()}, nil
+}
+
+func (t *httpTransport) Exchange(ctx context.Context, requests []Payload) ([]Payload, error) {
+	body, skipped := appendPayloadsToBase64URLEncoded(t.buf[:0], requests)
+	t.buf = body
+	warnSkipped("http", skipped...)
+	return sendPOSTRequest(ctx, t.url, body)
+}
+
+func (t *httpTransport) Close() error { return nil }
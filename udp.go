@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// UDP для домашней сети: датаграмма несёт один или несколько пакетов в
+// бинарном виде. Пакеты на ALL и на ещё не известные адреса уходят на
+// адрес из URL (широковещательный или multicast), остальные — на IP:порт,
+// с которого устройство присылало пакеты. Ответом на Exchange считается
+// всё, что пришло за transport.window после отправки. Сессия не кончается.
+//
+// udp://255.255.255.255:9999?listen=:9999 или udp://239.0.0.99:9999 для
+// multicast (listen тогда не нужен: слушается порт группы).
+const maxUDPDatagram = 1472
+
+type udpTransport struct {
+	cfg       TransportConfig
+	broadcast *net.UDPAddr
+	listen    string
+	conn      *net.UDPConn
+	learned   map[VarUint]*net.UDPAddr
+	self      map[VarUint]bool
+	buf       []byte
+	rbuf      []byte
+}
+
+func newUDPTransport(u *url.URL, cfg TransportConfig) (Transport, error) {
+	broadcast, err := net.ResolveUDPAddr("udp4", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("udp: %w", err)
+	}
+	listen := u.Query().Get("listen")
+	if listen == "" {
+		listen = fmt.Sprintf(":%d", broadcast.Port)
+	}
+	return &udpTransport{
+		cfg:       cfg,
+		broadcast: broadcast,
+		listen:    listen,
+		learned:   make(map[VarUint]*net.UDPAddr),
+		self:      make(map[VarUint]bool),
+		rbuf:      make([]byte, 64*1024),
+	}, nil
+}
+
+func (t *udpTransport) open(ctx context.Context) error {
+	if t.conn != nil {
+		return nil
+	}
+	if t.broadcast.IP.IsMulticast() {
+		// сокет группы слушает порт группы и получает и multicast, и unicast
+		conn, err := net.ListenMulticastUDP("udp4", nil, t.broadcast)
+		if err != nil {
+			return err
+		}
+		t.conn = conn
+	} else {
+		lc := net.ListenConfig{Control: enableBroadcast}
+		conn, err := lc.ListenPacket(ctx, "udp4", t.listen)
+		if err != nil {
+			return err
+		}
+		t.conn = conn.(*net.UDPConn)
+	}
+	logf(LogInfo, "udp: listening on %s, broadcast to %s", t.conn.LocalAddr(), t.broadcast)
+	return nil
+}
+
+func (t *udpTransport) route(dst VarUint) *net.UDPAddr {
+	if addr, ok := t.learned[dst]; ok && dst != ALL {
+		return addr
+	}
+	return t.broadcast
+}
+
+func (t *udpTransport) Exchange(ctx context.Context, requests []Payload) ([]Payload, error) {
+	if err := t.open(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &TransportError{Op: "listen", Err: err}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func(conn net.Conn) {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}(t.conn)
+
+	if err := t.send(requests); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	payloads, err := t.receive()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return payloads, err
+}
+
+// send раскладывает пакеты по датаграммам: по одной на адрес, пока влезает.
+func (t *udpTransport) send(requests []Payload) error {
+	var order []*net.UDPAddr
+	datagrams := make(map[string][]byte)
+	flush := func(addr *net.UDPAddr) error {
+		key := addr.String()
+		if len(datagrams[key]) == 0 {
+			return nil
+		}
+		_, err := t.conn.WriteToUDP(datagrams[key], addr)
+		datagrams[key] = datagrams[key][:0]
+		if err != nil {
+			return &TransportError{Op: "write", Err: err}
+		}
+		return nil
+	}
+	for _, p := range requests {
+		if p.Cmd == 0 {
+			continue
+		}
+		t.self[p.Src] = true
+		frame, err := appendPacket(t.buf[:0], p)
+		t.buf = frame
+		if err != nil {
+			warnSkipped("udp", packetError(p, err))
+			continue
+		}
+		addr := t.route(p.Dst)
+		key := addr.String()
+		if _, ok := datagrams[key]; !ok {
+			order = append(order, addr)
+		}
+		if len(datagrams[key])+len(frame) > maxUDPDatagram {
+			if err := flush(addr); err != nil {
+				return err
+			}
+		}
+		datagrams[key] = append(datagrams[key], frame...)
+	}
+	for _, addr := range order {
+		if err := flush(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive ждёт первую датаграмму не дольше window, затем забирает то, что
+// уже пришло следом. Срок общий на весь приём и только сокращается: поток
+// эха или мусора не задерживает Exchange дольше window.
+func (t *udpTransport) receive() ([]Payload, error) {
+	var payloads []Payload
+	deadline := time.Now().Add(time.Duration(t.cfg.Window))
+	for {
+		t.conn.SetReadDeadline(deadline)
+		n, from, err := t.conn.ReadFromUDP(t.rbuf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return payloads, nil
+		}
+		if err != nil {
+			return payloads, &TransportError{Op: "read", Err: err}
+		}
+		for _, p := range deserializeFromBinaryFormToPayloads(t.rbuf[:n]) {
+			// своё же широковещательное эхо
+			if t.self[p.Src] {
+				continue
+			}
+			if p.Src != ALL {
+				t.learned[p.Src] = from
+			}
+			payloads = append(payloads, p)
+		}
+		if soon := time.Now().Add(time.Millisecond); len(payloads) > 0 && soon.Before(deadline) {
+			deadline = soon
+		}
+	}
+}
+
+func (t *udpTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type udpDatagram struct {
+	payloads []Payload
+	from     *net.UDPAddr
+}
+
+func listenUDP(t *testing.T) (*net.UDPConn, <-chan udpDatagram) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	ch := make(chan udpDatagram, 8)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			ch <- udpDatagram{deserializeFromBinaryFormToPayloads(buf[:n]), from}
+		}
+	}()
+	return conn, ch
+}
+
+func sendUDP(t *testing.T, conn *net.UDPConn, to *net.UDPAddr, payloads ...Payload) {
+	bin, skipped := appendPackets(nil, payloads)
+	require.Empty(t, skipped)
+	_, err := conn.WriteToUDP(bin, to)
+	require.NoError(t, err)
+}
+
+func TestUDPTransport(t *testing.T) {
+	// на loopback широковещательный адрес изображает обычный сокет
+	bcast, bcastIn := listenUDP(t)
+	lamp, lampIn := listenUDP(t)
+
+	cfg := defaultTransportConfig()
+	cfg.Window = Duration(time.Second)
+	tr, err := NewTransport("udp://"+bcast.LocalAddr().String()+"?listen=127.0.0.1:0", cfg)
+	require.NoError(t, err)
+	defer tr.Close()
+
+	whois := Payload{Src: 1, Dst: ALL, Serial: 1, DevType: SMARTHUB, Cmd: WHOISHERE, CmdBody: DeviceCmdBody{DevName: "HUB00"}}
+	go func() {
+		d := <-bcastIn
+		// эхо собственной рассылки хаб должен отбросить
+		sendUDP(t, bcast, d.from, d.payloads...)
+		sendUDP(t, lamp, d.from, iamhere(7, LAMP, "LAMP01"))
+	}()
+	got, err := tr.Exchange(context.Background(), []Payload{whois})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, VarUint(7), got[0].Src)
+
+	go func() {
+		d := <-lampIn
+		for _, p := range d.payloads {
+			sendUDP(t, lamp, d.from, Payload{Src: 7, Dst: 1, Serial: 2, DevType: LAMP, Cmd: STATUS, CmdBody: Flag(p.Dst == 7)})
+		}
+	}()
+	got, err = tr.Exchange(context.Background(), []Payload{
+		{Src: 1, Dst: 7, Serial: 2, DevType: LAMP, Cmd: GETSTATUS},
+		{Src: 1, Dst: 9, Serial: 3, DevType: LAMP, Cmd: GETSTATUS},
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, Flag(true), got[0].CmdBody)
+	// неизвестный адрес ушёл на широковещательный
+	select {
+	case d := <-bcastIn:
+		require.Len(t, d.payloads, 1)
+		assert.Equal(t, VarUint(9), d.payloads[0].Dst)
+	case <-time.After(time.Second):
+		t.Fatal("no broadcast datagram")
+	}
+
+	// без ответов Exchange возвращается через window с пустым списком
+	cfg.Window = Duration(20 * time.Millisecond)
+	tr.(*udpTransport).cfg = cfg
+	got, err = tr.Exchange(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestUDPTransportNoisyWindow(t *testing.T) {
+	noise, _ := listenUDP(t)
+	cfg := defaultTransportConfig()
+	cfg.Window = Duration(50 * time.Millisecond)
+	tr, err := NewTransport("udp://"+noise.LocalAddr().String()+"?listen=127.0.0.1:0", cfg)
+	require.NoError(t, err)
+	defer tr.Close()
+	udp := tr.(*udpTransport)
+	require.NoError(t, udp.open(context.Background()))
+	hubAddr := udp.conn.LocalAddr().(*net.UDPAddr)
+
+	// эхо и мусор идут непрерывно и не должны продлевать окно
+	whois := Payload{Src: 1, Dst: ALL, Serial: 1, DevType: SMARTHUB, Cmd: WHOISHERE, CmdBody: DeviceCmdBody{DevName: "HUB00"}}
+	echo, skipped := appendPackets(nil, []Payload{whois})
+	require.Empty(t, skipped)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(2 * time.Millisecond):
+			}
+			noise.WriteToUDP(echo, hubAddr)
+			noise.WriteToUDP([]byte{0xFF, 0x01}, hubAddr)
+		}
+	}()
+	start := time.Now()
+	got, err := tr.Exchange(context.Background(), []Payload{whois})
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Без SO_BROADCAST ядро не даёт слать на широковещательный адрес.
+func enableBroadcast(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,50 @@
+package main
+
+import "errors"
+
+// ULEB128: 7 бит на байт, старший бит — признак продолжения.
+const maxVarUintLen = 10
+
+var (
+	errVarUintTruncated = errors.New("varuint: truncated")
+	errVarUintOverflow  = errors.New("varuint: overflows 64 bits")
+	errVarUintOverlong  = errors.New("varuint: overlong encoding")
+)
+
+func AppendVarUint(dst []byte, value VarUint) []byte {
+	for value >= 0x80 {
+		dst = append(dst, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(dst, byte(value))
+}
+
+func ReadVarUint(bin []byte) (VarUint, int, error) {
+	if len(bin) > 0 && bin[0] < 0x80 {
+		return VarUint(bin[0]), 1, nil
+	}
+	var value VarUint
+	for i := 0; i < len(bin) && i < maxVarUintLen; i++ {
+		b := bin[i]
+		if i == maxVarUintLen-1 && b > 1 {
+			return 0, 0, errVarUintOverflow
+		}
+		value |= VarUint(b&0x7F) << (7 * uint(i))
+		if b&0x80 == 0 {
+			if b == 0 && i > 0 {
+				return 0, 0, errVarUintOverlong
+			}
+			return value, i + 1, nil
+		}
+	}
+	return 0, 0, errVarUintTruncated
+}
+
+func VarUintLen(value VarUint) int {
+	n := 1
+	for value >= 0x80 {
+		value >>= 7
+		n++
+	}
+	return n
+}
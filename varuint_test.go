@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// прежняя реализация, оставлена для сравнения в бенчмарках
+func legacySerializeVarUint(w io.Writer, value VarUint) {
+	for value >= 0x80 {
+		binary.Write(w, binary.LittleEndian, byte(value)|0x80)
+		value >>= 7
+	}
+	binary.Write(w, binary.LittleEndian, byte(value))
+}
+
+func legacyDeserializeVarUint(bin []byte, startIndex, lastIndex int) (VarUint, int) {
+	var result, shift VarUint
+	i := startIndex
+	for i < lastIndex {
+		b := bin[i]
+		result |= VarUint(b&0x7F) << shift
+		shift += 7
+		i++
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return result, i
+}
+
+func TestVarUintRoundTrip(t *testing.T) {
+	values := []VarUint{0, 1, 0x7F, 0x80, 300, ALL, 1 << 35, math.MaxUint64 - 1, math.MaxUint64}
+	for _, val := range values {
+		bin := AppendVarUint(nil, val)
+		assert.Equal(t, VarUintLen(val), len(bin))
+		got, n, err := ReadVarUint(bin)
+		assert.NoError(t, err)
+		assert.Equal(t, len(bin), n)
+		assert.Equal(t, val, got)
+
+		buf := new(bytes.Buffer)
+		legacySerializeVarUint(buf, val)
+		assert.Equal(t, buf.Bytes(), bin)
+	}
+	assert.Equal(t, 10, VarUintLen(math.MaxUint64))
+}
+
+func TestVarUintInvalid(t *testing.T) {
+	_, _, err := ReadVarUint([]byte{0x80, 0x80})
+	assert.ErrorIs(t, err, errVarUintTruncated)
+	_, _, err = ReadVarUint(nil)
+	assert.ErrorIs(t, err, errVarUintTruncated)
+	_, _, err = ReadVarUint([]byte{0x80, 0x00})
+	assert.ErrorIs(t, err, errVarUintOverlong)
+	_, _, err = ReadVarUint([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x02})
+	assert.ErrorIs(t, err, errVarUintOverflow)
+	_, _, err = ReadVarUint([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01})
+	assert.ErrorIs(t, err, errVarUintOverflow)
+
+	_, _, err = deserializeVarUint([]byte{0xE0, 0x07}, 0, 1)
+	assert.ErrorIs(t, err, errVarUintTruncated)
+}
+
+var benchVarUints = []VarUint{1, 0x7F, 300, ALL, 1 << 35, math.MaxUint64}
+
+func BenchmarkVarUintEncodeLegacy(b *testing.B) {
+	buf := new(bytes.Buffer)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		for _, val := range benchVarUints {
+			legacySerializeVarUint(buf, val)
+		}
+	}
+}
+
+func BenchmarkVarUintEncodeAppend(b *testing.B) {
+	buf := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = buf[:0]
+		for _, val := range benchVarUints {
+			buf = AppendVarUint(buf, val)
+		}
+	}
+}
+
+func BenchmarkVarUintDecodeLegacy(b *testing.B) {
+	var bin []byte
+	for _, val := range benchVarUints {
+		bin = AppendVarUint(bin, val)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < len(bin); {
+			_, j = legacyDeserializeVarUint(bin, j, len(bin))
+		}
+	}
+}
+
+func BenchmarkVarUintDecodeRead(b *testing.B) {
+	var bin []byte
+	for _, val := range benchVarUints {
+		bin = AppendVarUint(bin, val)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < len(bin); {
+			_, n, _ := ReadVarUint(bin[j:])
+			j += n
+		}
+	}
+}
@@ -19,10 +19,11 @@ type waitRequests struct {
 	requests       []waitRequest
 	cntWithNotNull int
 	size           int
+	timeout        VarUint
 }
 
 func CreateWaitRequests() waitRequests {
-	return waitRequests{make([]waitRequest, 0, 1), 0, 0}
+	return waitRequests{make([]waitRequest, 0, 1), 0, 0, defaultResponseTimeout}
 }
 
 func (w *waitRequests) Add(x waitRequest) {
@@ -30,11 +31,20 @@ func (w *waitRequests) Add(x waitRequest) {
 	w.size++
 }
 
+func (w *waitRequests) Pending(cmd byte, address VarUint) bool {
+	for _, r := range w.requests {
+		if r.Cmd == cmd && r.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *waitRequests) CheckWaitRequests(timestamp VarUint) []VarUint {
 	ans := make([]VarUint, 0, 1)
 	cnt := 0
-	for i := 0; i < w.cntWithNotNull; i++ {
-		if w.requests[i].wasFirstTick && timestamp-w.requests[i].firstTick >= 300 {
+	for i, n := 0, w.cntWithNotNull; i < n; i++ {
+		if w.requests[i].wasFirstTick && timestamp-w.requests[i].firstTick >= w.timeout {
 			ans = append(ans, w.requests[i].Address)
 			w.size--
 			w.cntWithNotNull--